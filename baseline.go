@@ -0,0 +1,195 @@
+package evgjson
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/evergreen-ci/evergreen/db/bsonutil"
+	"github.com/evergreen-ci/evergreen/model/task"
+	"github.com/evergreen-ci/evergreen/plugin"
+	"github.com/evergreen-ci/evergreen/util"
+	"github.com/gorilla/mux"
+)
+
+// Baseline marks one task's document as the one that the rest of a series
+// is compared against.
+type Baseline struct {
+	ProjectId  string    `bson:"project_id" json:"project_id"`
+	Variant    string    `bson:"variant" json:"variant"`
+	TaskName   string    `bson:"task_name" json:"task_name"`
+	Name       string    `bson:"name" json:"name"`
+	TaskId     string    `bson:"task_id" json:"task_id"`
+	Revision   string    `bson:"revision" json:"revision"`
+	Author     string    `bson:"author" json:"author"`
+	Note       string    `bson:"note,omitempty" json:"note,omitempty"`
+	CreateTime time.Time `bson:"create_time" json:"create_time"`
+}
+
+var (
+	// BSON fields for the Baseline struct
+	BaselineProjectIdKey = bsonutil.MustHaveTag(Baseline{}, "ProjectId")
+	BaselineVariantKey   = bsonutil.MustHaveTag(Baseline{}, "Variant")
+	BaselineTaskNameKey  = bsonutil.MustHaveTag(Baseline{}, "TaskName")
+	BaselineNameKey      = bsonutil.MustHaveTag(Baseline{}, "Name")
+	BaselineTaskIdKey    = bsonutil.MustHaveTag(Baseline{}, "TaskId")
+)
+
+// BaselineComparison is a task's document compared to the baseline of its series.
+type BaselineComparison struct {
+	Name     string             `json:"name"`
+	TaskId   string             `json:"task_id"`
+	Baseline Baseline           `json:"baseline"`
+	Metrics  []MetricComparison `json:"metrics"`
+}
+
+// handleBaseline gets, sets or removes the baseline of a series. A POST or
+// PUT makes the document stored by the task with the "task_id" in its body
+// the baseline. Only admins may set or remove one.
+func handleBaseline(w http.ResponseWriter, r *http.Request) {
+	key := SeriesKey{
+		ProjectId: mux.Vars(r)["project_id"],
+		Variant:   mux.Vars(r)["variant"],
+		TaskName:  mux.Vars(r)["task_name"],
+		Name:      mux.Vars(r)["name"],
+	}
+	if r.Method != "GET" && !checkAdmin(w, r, key.ProjectId, "change a baseline") {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		baseline, err := jsonStore.FindBaseline(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if baseline == nil {
+			http.Error(w, "{}", http.StatusNotFound)
+			return
+		}
+		plugin.WriteJSON(w, http.StatusOK, baseline)
+	case "POST", "PUT":
+		in := struct {
+			TaskId string `json:"task_id"`
+			Note   string `json:"note"`
+		}{}
+		if err := util.ReadJSONInto(r.Body, &in); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if in.TaskId == "" {
+			http.Error(w, "task_id must not be blank", http.StatusBadRequest)
+			return
+		}
+		jsonForTask, err := jsonStore.FindByTaskId(in.TaskId, key.Name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if jsonForTask == nil {
+			http.Error(w, fmt.Sprintf("task '%v' has no data for '%v'", in.TaskId, key.Name), http.StatusNotFound)
+			return
+		}
+		if !key.matches(jsonForTask) {
+			http.Error(w, fmt.Sprintf("task '%v' is not part of this series", in.TaskId), http.StatusBadRequest)
+			return
+		}
+		author := ""
+		if u := plugin.GetUser(r); u != nil {
+			author = u.Username()
+		}
+		err = jsonStore.SetBaseline(&Baseline{
+			ProjectId:  key.ProjectId,
+			Variant:    key.Variant,
+			TaskName:   key.TaskName,
+			Name:       key.Name,
+			TaskId:     in.TaskId,
+			Revision:   jsonForTask.Revision,
+			Author:     author,
+			Note:       in.Note,
+			CreateTime: time.Now(),
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		plugin.WriteJSON(w, http.StatusOK, "")
+	case "DELETE":
+		if err := jsonStore.RemoveBaseline(key); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		plugin.WriteJSON(w, http.StatusOK, "")
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func compareToBaseline(t *task.Task, w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	current, err := jsonStore.FindByTaskId(t.Id, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if current == nil {
+		http.Error(w, "{}", http.StatusNotFound)
+		return
+	}
+
+	baseline, err := jsonStore.FindBaseline(SeriesKey{
+		ProjectId: t.Project,
+		Variant:   t.BuildVariant,
+		TaskName:  t.DisplayName,
+		Name:      name,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if baseline == nil {
+		http.Error(w, "no baseline is set", http.StatusNotFound)
+		return
+	}
+	baseJSON, err := jsonStore.FindByTaskId(baseline.TaskId, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if baseJSON == nil {
+		http.Error(w, "the baseline's data no longer exists", http.StatusNotFound)
+		return
+	}
+
+	plugin.WriteJSON(w, http.StatusOK, BaselineComparison{
+		Name:     name,
+		TaskId:   t.Id,
+		Baseline: *baseline,
+		Metrics:  compareMetrics(baseJSON.Data, current.Data),
+	})
+}
+
+// apiCompareToBaseline compares the requesting task's document to its series' baseline.
+func apiCompareToBaseline(w http.ResponseWriter, r *http.Request) {
+	t := plugin.GetTask(r)
+	if t == nil {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+	compareToBaseline(t, w, r)
+}
+
+// uiCompareToBaseline compares a task's document to its series' baseline.
+func uiCompareToBaseline(w http.ResponseWriter, r *http.Request) {
+	t, err := task.FindOne(task.ById(mux.Vars(r)["task_id"]))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if t == nil {
+		http.Error(w, "{}", http.StatusNotFound)
+		return
+	}
+	compareToBaseline(t, w, r)
+}
@@ -0,0 +1,76 @@
+package evgjson
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleBaseline(t *testing.T) {
+	windows := seriesDoc("w1", 1, map[string]interface{}{"ops": 1.0})
+	windows.Variant = "windows"
+	docs := []TaskJSON{
+		seriesDoc("t1", 1, map[string]interface{}{"ops": 10.0}),
+		seriesDoc("t2", 2, map[string]interface{}{"ops": 15.0}),
+		windows,
+	}
+	url := "/baseline/p/linux/bench/perf"
+
+	withMemoryStore(t, docs, func() {
+		settings = Settings{Projects: map[string]ProjectSettings{"p": {Admins: []string{"admin"}}}}
+
+		for _, r := range []*http.Request{
+			newRequest("POST", url, map[string]string{"task_id": "t1"}),
+			asUser(newRequest("PUT", url, map[string]string{"task_id": "t1"}), "someone"),
+			asUser(newRequest("DELETE", url, nil), "someone"),
+		} {
+			if w := serveUI(r); w.Code != http.StatusForbidden {
+				t.Errorf("%v by a non-admin: expected 403, got %v", r.Method, w.Code)
+			}
+		}
+		if w := serveUI(httptest.NewRequest("GET", url, nil)); w.Code != http.StatusNotFound {
+			t.Fatalf("expected no baseline to be set, got %v", w.Code)
+		}
+
+		cases := map[string]int{"": http.StatusBadRequest, "t9": http.StatusNotFound, "w1": http.StatusBadRequest}
+		for taskId, code := range cases {
+			r := asUser(newRequest("POST", url, map[string]string{"task_id": taskId}), "admin")
+			if w := serveUI(r); w.Code != code {
+				t.Errorf("task '%v': expected %v, got %v", taskId, code, w.Code)
+			}
+		}
+		r := asUser(newRequest("POST", url, map[string]string{"task_id": "t1", "note": "before the rewrite"}), "admin")
+		if w := serveUI(r); w.Code != http.StatusOK {
+			t.Fatalf("expected the baseline to be set, got %v: %v", w.Code, w.Body.String())
+		}
+		w := serveUI(httptest.NewRequest("GET", url, nil))
+		baseline := Baseline{}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &baseline) != nil {
+			t.Fatalf("expected the baseline, got %v: %v", w.Code, w.Body.String())
+		}
+		if baseline.TaskId != "t1" || baseline.Revision != "r1" || baseline.Author != "admin" ||
+			baseline.Note != "before the rewrite" {
+			t.Errorf("expected t1's baseline set by the admin, got %+v", baseline)
+		}
+
+		w = serveRoute("/compare/{name}/baseline", forTask(seriesTask("t2", 2), compareToBaseline),
+			httptest.NewRequest("GET", "/compare/perf/baseline", nil))
+		comparison := BaselineComparison{}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &comparison) != nil {
+			t.Fatalf("expected the comparison, got %v: %v", w.Code, w.Body.String())
+		}
+		if len(comparison.Metrics) != 1 || comparison.Metrics[0].Delta != 5 {
+			t.Errorf("expected ops to have gone up by 5, got %+v", comparison.Metrics)
+		}
+
+		if w = serveUI(asUser(httptest.NewRequest("DELETE", url, nil), "admin")); w.Code != http.StatusOK {
+			t.Fatalf("expected the baseline to be removed, got %v", w.Code)
+		}
+		w = serveRoute("/compare/{name}/baseline", forTask(seriesTask("t2", 2), compareToBaseline),
+			httptest.NewRequest("GET", "/compare/perf/baseline", nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected no comparison without a baseline, got %v", w.Code)
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package evgjson
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/evergreen-ci/evergreen"
+	"github.com/evergreen-ci/evergreen/model/task"
+	"github.com/evergreen-ci/evergreen/plugin"
+	"github.com/gorilla/mux"
+)
+
+// MetricComparison compares one number in two documents.
+type MetricComparison struct {
+	Path  string  `json:"path"`
+	Base  float64 `json:"base"`
+	Value float64 `json:"value"`
+	Delta float64 `json:"delta"`
+	// PercentChange is left out when the base value is zero.
+	PercentChange *float64 `json:"percent_change,omitempty"`
+}
+
+// PatchComparison is a patch task's document next to the document its
+// base commit stored under the same name.
+type PatchComparison struct {
+	Name        string             `json:"name"`
+	PatchTaskId string             `json:"patch_task_id"`
+	BaseTaskId  string             `json:"base_task_id"`
+	Patch       interface{}        `json:"patch"`
+	Base        interface{}        `json:"base"`
+	Metrics     []MetricComparison `json:"metrics"`
+}
+
+// compareMetrics compares every number found at the same path in both
+// documents, sorted by path.
+func compareMetrics(base, current interface{}) []MetricComparison {
+	baseLeaves := flatten(base)
+	currentLeaves := flatten(current)
+	paths := []string{}
+	for path := range currentLeaves {
+		if _, ok := baseLeaves[path]; ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	metrics := []MetricComparison{}
+	for _, path := range paths {
+		baseNum, ok := toFloat(baseLeaves[path])
+		if !ok {
+			continue
+		}
+		currentNum, ok := toFloat(currentLeaves[path])
+		if !ok {
+			continue
+		}
+		delta, percent := numericChange(baseNum, currentNum)
+		metrics = append(metrics, MetricComparison{
+			Path:          path,
+			Base:          baseNum,
+			Value:         currentNum,
+			Delta:         *delta,
+			PercentChange: percent,
+		})
+	}
+	return metrics
+}
+
+func comparePatchToBase(t *task.Task, w http.ResponseWriter, r *http.Request) {
+	if t.Requester != evergreen.PatchVersionRequester {
+		http.Error(w, "task is not part of a patch", http.StatusBadRequest)
+		return
+	}
+	name := mux.Vars(r)["name"]
+
+	patchJSON, err := jsonStore.FindByTaskId(t.Id, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if patchJSON == nil {
+		http.Error(w, "{}", http.StatusNotFound)
+		return
+	}
+
+	base, err := t.FindTaskOnBaseCommit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if base == nil {
+		http.Error(w, "no task found on the base commit", http.StatusNotFound)
+		return
+	}
+	baseJSON, err := jsonStore.FindByTaskId(base.Id, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if baseJSON == nil {
+		http.Error(w, "no data found on the base commit", http.StatusNotFound)
+		return
+	}
+
+	plugin.WriteJSON(w, http.StatusOK, PatchComparison{
+		Name:        name,
+		PatchTaskId: t.Id,
+		BaseTaskId:  base.Id,
+		Patch:       patchJSON.Data,
+		Base:        baseJSON.Data,
+		Metrics:     compareMetrics(baseJSON.Data, patchJSON.Data),
+	})
+}
+
+// apiComparePatchToBase compares the requesting patch task's document to its base commit's.
+func apiComparePatchToBase(w http.ResponseWriter, r *http.Request) {
+	t := plugin.GetTask(r)
+	if t == nil {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+	comparePatchToBase(t, w, r)
+}
+
+// uiComparePatchToBase compares a patch task's document to its base commit's.
+func uiComparePatchToBase(w http.ResponseWriter, r *http.Request) {
+	t, err := task.FindOne(task.ById(mux.Vars(r)["task_id"]))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if t == nil {
+		http.Error(w, "{}", http.StatusNotFound)
+		return
+	}
+	comparePatchToBase(t, w, r)
+}
@@ -0,0 +1,32 @@
+package evgjson
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCompareMetrics(t *testing.T) {
+	base := map[string]interface{}{"ops": 100.0, "zero": 0.0, "label": "x", "only_base": 1.0}
+	current := map[string]interface{}{"ops": 150.0, "zero": 5.0, "label": "y", "only_current": 1.0}
+	metrics := compareMetrics(base, current)
+	if len(metrics) != 2 || metrics[0].Path != "ops" || metrics[1].Path != "zero" {
+		t.Fatalf("expected ops and zero to be compared, got %+v", metrics)
+	}
+	ops := metrics[0]
+	if ops.Base != 100 || ops.Value != 150 || ops.Delta != 50 || ops.PercentChange == nil || *ops.PercentChange != 50 {
+		t.Errorf("expected a change of 50 and 50%%, got %+v", ops)
+	}
+	if zero := metrics[1]; zero.Delta != 5 || zero.PercentChange != nil {
+		t.Errorf("expected a change of 5 and no percent change from zero, got %+v", zero)
+	}
+}
+
+func TestComparePatchToBaseNotPatch(t *testing.T) {
+	withMemoryStore(t, []TaskJSON{seriesDoc("t1", 1, map[string]interface{}{"ops": 1.0})}, func() {
+		w := serveRoute("/compare/{name}", forTask(seriesTask("t1", 1), comparePatchToBase),
+			newRequest("GET", "/compare/perf", nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for a mainline task, got %v", w.Code)
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package evgjson
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/evergreen-ci/evergreen/plugin"
+	"github.com/gorilla/mux"
+)
+
+// Kinds of difference between two documents.
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+)
+
+// DiffEntry is a single difference between two documents.
+type DiffEntry struct {
+	// Path is the dot-path of the value that differs.
+	Path string `json:"path"`
+	// Op is DiffAdded, DiffRemoved or DiffChanged.
+	Op  string      `json:"op"`
+	Old interface{} `json:"old,omitempty"`
+	New interface{} `json:"new,omitempty"`
+	// Delta and PercentChange are set when a number changed. PercentChange
+	// is left out when the old value was zero.
+	Delta         *float64 `json:"delta,omitempty"`
+	PercentChange *float64 `json:"percent_change,omitempty"`
+}
+
+// DataDiff is the structured difference between the documents two tasks
+// stored under the same name.
+type DataDiff struct {
+	Name    string      `json:"name"`
+	TaskIdA string      `json:"task_id_a"`
+	TaskIdB string      `json:"task_id_b"`
+	Changes []DiffEntry `json:"changes"`
+}
+
+// diffData returns the differences that turn document a into document b.
+// Objects are compared key by key and arrays index by index; any other
+// pair of values that is not equal is reported as changed.
+func diffData(a, b interface{}) []DiffEntry {
+	changes := []DiffEntry{}
+	diffValues("", a, b, &changes)
+	return changes
+}
+
+func diffValues(path string, a, b interface{}, changes *[]DiffEntry) {
+	aMap, aIsMap := asMap(a)
+	bMap, bIsMap := asMap(b)
+	if aIsMap && bIsMap {
+		keys := []string{}
+		for k := range aMap {
+			keys = append(keys, k)
+		}
+		for k := range bMap {
+			if _, ok := aMap[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			aVal, inA := aMap[k]
+			bVal, inB := bMap[k]
+			switch {
+			case !inA:
+				*changes = append(*changes, DiffEntry{Path: joinPath(path, k), Op: DiffAdded, New: bVal})
+			case !inB:
+				*changes = append(*changes, DiffEntry{Path: joinPath(path, k), Op: DiffRemoved, Old: aVal})
+			default:
+				diffValues(joinPath(path, k), aVal, bVal, changes)
+			}
+		}
+		return
+	}
+
+	aSlice, aIsSlice := a.([]interface{})
+	bSlice, bIsSlice := b.([]interface{})
+	if aIsSlice && bIsSlice {
+		for i := 0; i < len(aSlice) || i < len(bSlice); i++ {
+			elemPath := joinPath(path, strconv.Itoa(i))
+			switch {
+			case i >= len(aSlice):
+				*changes = append(*changes, DiffEntry{Path: elemPath, Op: DiffAdded, New: bSlice[i]})
+			case i >= len(bSlice):
+				*changes = append(*changes, DiffEntry{Path: elemPath, Op: DiffRemoved, Old: aSlice[i]})
+			default:
+				diffValues(elemPath, aSlice[i], bSlice[i], changes)
+			}
+		}
+		return
+	}
+
+	aNum, aIsNum := toFloat(a)
+	bNum, bIsNum := toFloat(b)
+	if aIsNum && bIsNum {
+		if aNum != bNum {
+			entry := DiffEntry{Path: path, Op: DiffChanged, Old: a, New: b}
+			entry.Delta, entry.PercentChange = numericChange(aNum, bNum)
+			*changes = append(*changes, entry)
+		}
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		*changes = append(*changes, DiffEntry{Path: path, Op: DiffChanged, Old: a, New: b})
+	}
+}
+
+// numericChange returns the difference from one number to another, and
+// that difference as a percentage of the first unless it is zero.
+func numericChange(from, to float64) (*float64, *float64) {
+	delta := to - from
+	if from == 0 {
+		return &delta, nil
+	}
+	percent := delta / from * 100
+	return &delta, &percent
+}
+
+// getDiff sends back the differences between the documents two tasks
+// stored under a name. The "execution_a" and "execution_b" parameters pick
+// executions of the tasks other than their latest, so two executions of
+// one task can be compared.
+func getDiff(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	taskIdA := mux.Vars(r)["task_id_a"]
+	taskIdB := mux.Vars(r)["task_id_b"]
+	executionA, err := executionParam(r, "execution_a")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	executionB, err := executionParam(r, "execution_b")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	jsonA, err := findExecution(taskIdA, name, executionA)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonB, err := findExecution(taskIdB, name, executionB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if jsonA == nil || jsonB == nil {
+		http.Error(w, "{}", http.StatusNotFound)
+		return
+	}
+
+	plugin.WriteJSON(w, http.StatusOK, DataDiff{
+		Name:    name,
+		TaskIdA: taskIdA,
+		TaskIdB: taskIdB,
+		Changes: diffData(jsonA.Data, jsonB.Data),
+	})
+}
@@ -0,0 +1,96 @@
+package evgjson
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestDiffData(t *testing.T) {
+	a := map[string]interface{}{
+		"same":    "x",
+		"removed": true,
+		"num":     10.0,
+		"zero":    0.0,
+		"list":    []interface{}{1.0, 2.0},
+		"nested":  map[string]interface{}{"s": "old"},
+	}
+	b := map[string]interface{}{
+		"same":   "x",
+		"added":  1.0,
+		"num":    15.0,
+		"zero":   5.0,
+		"list":   []interface{}{1.0},
+		"nested": map[string]interface{}{"s": "new"},
+	}
+	changes := diffData(a, b)
+	byPath := map[string]DiffEntry{}
+	for _, change := range changes {
+		byPath[change.Path] = change
+	}
+	expectedOps := map[string]string{
+		"added":    DiffAdded,
+		"removed":  DiffRemoved,
+		"num":      DiffChanged,
+		"zero":     DiffChanged,
+		"list.1":   DiffRemoved,
+		"nested.s": DiffChanged,
+	}
+	if len(changes) != len(expectedOps) {
+		t.Errorf("expected %v changes, got %v: %+v", len(expectedOps), len(changes), changes)
+	}
+	for path, op := range expectedOps {
+		if byPath[path].Op != op {
+			t.Errorf("path '%v': expected op '%v', got %+v", path, op, byPath[path])
+		}
+	}
+
+	num := byPath["num"]
+	if num.Delta == nil || *num.Delta != 5 || num.PercentChange == nil || *num.PercentChange != 50 {
+		t.Errorf("expected a delta of 5 and a change of 50%%, got %+v", num)
+	}
+	if zero := byPath["zero"]; zero.Delta == nil || zero.PercentChange != nil {
+		t.Errorf("expected a delta and no percent change from zero, got %+v", zero)
+	}
+	if s := byPath["nested.s"]; s.Delta != nil {
+		t.Errorf("expected no delta for a string, got %+v", s)
+	}
+}
+
+func TestDiffDataEqual(t *testing.T) {
+	doc := map[string]interface{}{"a": []interface{}{map[string]interface{}{"b": 1.0}}}
+	if changes := diffData(doc, doc); len(changes) != 0 {
+		t.Errorf("expected no changes, got %+v", changes)
+	}
+	// top-level values that are not objects are compared at the empty path
+	changes := diffData(1.0, "1")
+	expected := []DiffEntry{{Path: "", Op: DiffChanged, Old: 1.0, New: "1"}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected %+v, got %+v", expected, changes)
+	}
+}
+
+func TestGetDiff(t *testing.T) {
+	docs := []TaskJSON{
+		seriesDoc("t1", 1, map[string]interface{}{"ops": 100.0, "label": "x"}),
+		seriesDoc("t2", 2, map[string]interface{}{"ops": 80.0}),
+	}
+	withMemoryStore(t, docs, func() {
+		w := serveUI(newRequest("GET", "/diff/t1/t2/perf", nil))
+		diff := DataDiff{}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &diff) != nil {
+			t.Fatalf("expected a diff, got %v: %v", w.Code, w.Body.String())
+		}
+		if diff.Name != "perf" || diff.TaskIdA != "t1" || diff.TaskIdB != "t2" {
+			t.Errorf("expected the diff of t1 and t2, got %+v", diff)
+		}
+		if len(diff.Changes) != 2 || diff.Changes[0].Path != "label" || diff.Changes[1].Path != "ops" {
+			t.Errorf("expected changes to label and ops, got %+v", diff.Changes)
+		}
+
+		if w = serveUI(newRequest("GET", "/diff/t1/t3/perf", nil)); w.Code != http.StatusNotFound {
+			t.Errorf("missing task: expected 404, got %v", w.Code)
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package evgjson
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/10gen-labs/slogger/v1"
+	"github.com/evergreen-ci/evergreen"
+)
+
+const (
+	// Values of the "format" parameter of routes that send back a list of
+	// documents.
+	jsonFormat = "json"
+	csvFormat  = "csv"
+	tsvFormat  = "tsv"
+)
+
+// exportColumns are the columns of an exported table that come before the
+// columns for the documents' data.
+var exportColumns = []string{"revision", "order", "variant", "task", "create_time"}
+
+// tableFormat reads the "format" parameter of a route that sends back a
+// list of documents. It returns csvFormat or tsvFormat for a table, or ""
+// for json, the default.
+func tableFormat(r *http.Request) (string, error) {
+	switch format := r.FormValue("format"); format {
+	case "", jsonFormat:
+		return "", nil
+	case csvFormat, tsvFormat:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown format '%v': must be '%v', '%v' or '%v'",
+			format, jsonFormat, csvFormat, tsvFormat)
+	}
+}
+
+// tableColumns reads the documents iter passes once to find the dot-paths
+// to a value in any of their data, which are the table's data columns. It
+// also returns the number of documents.
+func tableColumns(iter docIter) ([]string, int, error) {
+	seen := map[string]bool{}
+	paths := []string{}
+	count := 0
+	err := iter(func(doc *TaskJSON) error {
+		count++
+		for path := range flatten(doc.Data) {
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	sort.Strings(paths)
+	return paths, count, nil
+}
+
+// writeTable sends the documents iter passes as a table with a row for
+// each document, in a format from tableFormat. The documents are read
+// twice, first for the columns and then for the rows, so that neither
+// they nor the rows are ever all held in memory. If there are no
+// documents, emptyStatus is sent instead.
+func writeTable(w http.ResponseWriter, format string, emptyStatus int, iter docIter) {
+	paths, count, err := tableColumns(iter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if count == 0 && emptyStatus != http.StatusOK {
+		http.Error(w, "{}", emptyStatus)
+		return
+	}
+	writeTableRows(w, format, paths, iter)
+}
+
+// writeTableRows sends the documents iter passes as a table with the given
+// data columns, writing each row as its document is read. Once the header
+// is sent the status can no longer change, so an error cuts the table
+// short and is only logged.
+func writeTableRows(w http.ResponseWriter, format string, paths []string, iter docIter) {
+	out := csv.NewWriter(w)
+	if format == tsvFormat {
+		out.Comma = '\t'
+		w.Header().Set("Content-Type", "text/tab-separated-values; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	}
+	w.WriteHeader(http.StatusOK)
+
+	header := append([]string{}, exportColumns...)
+	for _, path := range paths {
+		// data that is not an object or array is a leaf at the empty path
+		if path == "" {
+			path = DataKey
+		}
+		header = append(header, path)
+	}
+	if err := out.Write(header); err != nil {
+		return
+	}
+	err := iter(func(doc *TaskJSON) error {
+		leaves := flatten(doc.Data)
+		record := []string{doc.Revision, strconv.Itoa(doc.RevisionOrderNumber), doc.Variant, doc.TaskName,
+			doc.CreateTime.Format(time.RFC3339)}
+		for _, path := range paths {
+			record = append(record, formatCell(leaves[path]))
+		}
+		return out.Write(record)
+	})
+	out.Flush()
+	if err != nil {
+		evergreen.Logger.Logf(slogger.ERROR, "Error writing table of documents: %v", err)
+	}
+}
+
+// formatCell formats a leaf value of a document for a table.
+func formatCell(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+	return fmt.Sprint(value)
+}
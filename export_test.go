@@ -0,0 +1,99 @@
+package evgjson
+
+import (
+	"encoding/csv"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// versionDocs returns the perf documents of version v1 on two variants.
+func versionDocs() []TaskJSON {
+	linux := seriesDoc("t1", 1, map[string]interface{}{"ops": 100.0, "results": []interface{}{map[string]interface{}{"ops": 1.0}}})
+	windows := seriesDoc("w1", 1, map[string]interface{}{"ops": 80.0, "label": "x"})
+	windows.Variant = "windows"
+	return []TaskJSON{linux, windows}
+}
+
+// readTable reads a csv table, failing the test if it isn't one.
+func readTable(t *testing.T, body string, comma rune) [][]string {
+	table := csv.NewReader(strings.NewReader(body))
+	table.Comma = comma
+	records, err := table.ReadAll()
+	if err != nil {
+		t.Fatalf("expected a table, got %v: %v", err, body)
+	}
+	return records
+}
+
+func TestExportVersion(t *testing.T) {
+	withMemoryStore(t, versionDocs(), func() {
+		w := serveGet("/version/v1/perf/?format=csv", "")
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+			t.Fatalf("expected csv, got %v: %v", w.Code, w.Header())
+		}
+		records := readTable(t, w.Body.String(), ',')
+		expectedHeader := append(append([]string{}, exportColumns...), "label", "ops", "results.0.ops")
+		if len(records) != 3 || !reflect.DeepEqual(records[0], expectedHeader) {
+			t.Fatalf("unexpected table %v", records)
+		}
+		rows := map[string][]string{}
+		for _, record := range records[1:] {
+			rows[record[2]] = record
+		}
+		expected := map[string][]string{
+			"linux":   {"r1", "1", "linux", "bench", "2016-01-01T00:00:00Z", "", "100", "1"},
+			"windows": {"r1", "1", "windows", "bench", "2016-01-01T00:00:00Z", "x", "80", ""},
+		}
+		if !reflect.DeepEqual(rows, expected) {
+			t.Errorf("expected rows %v, got %v", expected, rows)
+		}
+
+		w = serveGet("/version/v1/perf/?format=tsv", "")
+		if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/tab-separated-values") {
+			t.Errorf("expected tsv, got %v", w.Header())
+		}
+		if records = readTable(t, w.Body.String(), '\t'); len(records) != 3 || !reflect.DeepEqual(records[0], expectedHeader) {
+			t.Errorf("unexpected table %v", records)
+		}
+
+		if w = serveGet("/version/v1/perf/?format=xml", ""); w.Code != http.StatusBadRequest {
+			t.Errorf("unknown format: expected 400, got %v", w.Code)
+		}
+		if w = serveGet("/version/v2/perf/?format=csv", ""); w.Code != http.StatusNotFound {
+			t.Errorf("missing version: expected 404, got %v", w.Code)
+		}
+	})
+}
+
+func TestExportHistoryPage(t *testing.T) {
+	withMemoryStore(t, historyDocs(), func() {
+		handler := forTask(seriesTask("t3", 3), getTaskHistory)
+		w := serveRoute("/history/{name}", handler, newRequest("GET", "/history/perf?format=csv&limit=2", nil))
+		if w.Code != http.StatusOK || w.Header().Get(nextCursorHeader) != "2" {
+			t.Fatalf("expected a page followed by another, got %v: %v", w.Code, w.Header())
+		}
+		records := readTable(t, w.Body.String(), ',')
+		pageOrders := []string{}
+		for _, record := range records[1:] {
+			pageOrders = append(pageOrders, record[1])
+		}
+		if !reflect.DeepEqual(records[0][len(exportColumns):], []string{"ops"}) || !reflect.DeepEqual(pageOrders, []string{"1", "2"}) {
+			t.Errorf("expected orders 1 and 2 with their ops, got %v", records)
+		}
+
+		w = serveRoute("/history/{name}", handler, newRequest("GET", "/history/perf?format=tsv&cursor=4", nil))
+		if w.Header().Get(nextCursorHeader) != "" {
+			t.Errorf("expected the last page to have no cursor, got %v", w.Header().Get(nextCursorHeader))
+		}
+		if records = readTable(t, w.Body.String(), '\t'); len(records) != 2 || records[1][1] != "5" {
+			t.Errorf("expected order 5, got %v", records)
+		}
+
+		w = serveRoute("/history/{name}", handler, newRequest("GET", "/history/perf?format=csv&window=1", nil))
+		if records = readTable(t, w.Body.String(), ','); len(records) != 3 {
+			t.Errorf("expected the window of two documents, got %v", records)
+		}
+	})
+}
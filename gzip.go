@@ -0,0 +1,168 @@
+package evgjson
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gzipEncoding is the value of the "encoding" parameter of the insert
+// route for a body that is a json string holding base64 encoded, gzipped
+// json. It lets the agent, which cannot set headers on its requests, send
+// compressed data.
+const gzipEncoding = "gzip"
+
+// errPayloadTooLarge is returned by readPayload for a body over the size limit.
+type errPayloadTooLarge struct {
+	limit int64
+}
+
+func (e errPayloadTooLarge) Error() string {
+	return fmt.Sprintf("data must not be larger than %v bytes", e.limit)
+}
+
+// readLimited reads all of r, failing if it holds more than limit bytes.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, errPayloadTooLarge{limit}
+	}
+	return body, nil
+}
+
+// gunzipLimited decompresses gzipped data, failing if it expands to more
+// than limit bytes.
+func gunzipLimited(r io.Reader, limit int64) ([]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gzip data: %v", err)
+	}
+	defer gz.Close()
+	return readLimited(gz, limit)
+}
+
+// readPayload reads the json sent to the insert route. The body may be
+// gzipped with a "Content-Encoding: gzip" header, or sent in the agent's
+// encoding when the "encoding" parameter is "gzip". Both the body and the
+// json it holds must be within limit bytes.
+func readPayload(r *http.Request, limit int64) ([]byte, error) {
+	body, err := readLimited(r.Body, limit)
+	if err != nil {
+		return nil, err
+	}
+	if r.Header.Get("Content-Encoding") == gzipEncoding {
+		if body, err = gunzipLimited(bytes.NewReader(body), limit); err != nil {
+			return nil, err
+		}
+	}
+	if r.URL.Query().Get("encoding") == gzipEncoding {
+		compressed := []byte{}
+		if err = json.Unmarshal(body, &compressed); err != nil {
+			return nil, fmt.Errorf("gzip encoded data must be a base64 string: %v", err)
+		}
+		if body, err = gunzipLimited(bytes.NewReader(compressed), limit); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+// gzipData compresses the json encoding of data for posting with the
+// "encoding" parameter set to "gzip".
+func gzipData(data interface{}) ([]byte, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	compressed := &bytes.Buffer{}
+	gz := gzip.NewWriter(compressed)
+	if _, err = gz.Write(raw); err != nil {
+		return nil, err
+	}
+	if err = gz.Close(); err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+// readResponse reads the body of a response, decompressing it if the
+// server gzipped it and the client did not already.
+func readResponse(resp *http.Response) ([]byte, error) {
+	if resp.Header.Get("Content-Encoding") != gzipEncoding {
+		return ioutil.ReadAll(resp.Body)
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return ioutil.ReadAll(gz)
+}
+
+// gzipResponseWriter compresses everything written to it.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz *gzip.Writer
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	// the length of the uncompressed body no longer applies
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	return w.gz.Write(b)
+}
+
+// Flush sends everything compressed so far to the client.
+func (w *gzipResponseWriter) Flush() {
+	w.gz.Flush()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// gzipHandler compresses the responses of GET requests from clients that
+// accept gzip.
+func gzipHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || !acceptsGzip(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Encoding", gzipEncoding)
+		w.Header().Add("Vary", "Accept-Encoding")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		h.ServeHTTP(&gzipResponseWriter{ResponseWriter: w, gz: gz}, r)
+	})
+}
+
+// acceptsGzip returns whether the Accept-Encoding header of a request allows gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(encoding, ";")
+		if strings.TrimSpace(parts[0]) != gzipEncoding {
+			continue
+		}
+		// a quality of zero means the client refuses gzip
+		for _, param := range parts[1:] {
+			q, err := strconv.ParseFloat(strings.TrimPrefix(strings.TrimSpace(param), "q="), 64)
+			if err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
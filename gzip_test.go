@@ -0,0 +1,135 @@
+package evgjson
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/evergreen-ci/evergreen/plugin"
+)
+
+func gzipped(t *testing.T, data string) []byte {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	if _, err := gz.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadPayload(t *testing.T) {
+	data := `{"a":1}`
+
+	r := httptest.NewRequest("POST", "/data/perf", strings.NewReader(data))
+	body, err := readPayload(r, 100)
+	if err != nil || string(body) != data {
+		t.Errorf("plain body: expected %v, got %s (%v)", data, body, err)
+	}
+
+	r = httptest.NewRequest("POST", "/data/perf", bytes.NewReader(gzipped(t, data)))
+	r.Header.Set("Content-Encoding", gzipEncoding)
+	body, err = readPayload(r, 100)
+	if err != nil || string(body) != data {
+		t.Errorf("gzipped body: expected %v, got %s (%v)", data, body, err)
+	}
+
+	compressed, err := gzipData(map[string]int{"a": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the agent posts the compressed bytes as json, which is a base64 string
+	encoded, err := json.Marshal(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = httptest.NewRequest("POST", "/data/perf?encoding=gzip", bytes.NewReader(encoded))
+	body, err = readPayload(r, 100)
+	if err != nil || string(body) != data {
+		t.Errorf("gzip encoded body: expected %v, got %s (%v)", data, body, err)
+	}
+
+	r = httptest.NewRequest("POST", "/data/perf", strings.NewReader(data))
+	if _, err = readPayload(r, 3); err != (errPayloadTooLarge{3}) {
+		t.Errorf("body over the limit: expected errPayloadTooLarge, got %v", err)
+	}
+
+	// the limit applies to the data once it is decompressed
+	large := `"` + strings.Repeat("x", 1000) + `"`
+	r = httptest.NewRequest("POST", "/data/perf", bytes.NewReader(gzipped(t, large)))
+	r.Header.Set("Content-Encoding", gzipEncoding)
+	if _, err = readPayload(r, 100); err != (errPayloadTooLarge{100}) {
+		t.Errorf("decompressed body over the limit: expected errPayloadTooLarge, got %v", err)
+	}
+
+	r = httptest.NewRequest("POST", "/data/perf?encoding=gzip", strings.NewReader(data))
+	if _, err = readPayload(r, 100); err == nil {
+		t.Errorf("expected an error for gzip encoded data that is not a string")
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	cases := map[string]bool{
+		"":                    false,
+		"gzip":                true,
+		"deflate, gzip;q=0.5": true,
+		"identity":            false,
+		"gzip;q=0":            false,
+		"br, gzip ; q=0.0, *": false,
+		"x-gzip":              false,
+	}
+	for header, expected := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", header)
+		if accepts := acceptsGzip(r); accepts != expected {
+			t.Errorf("'%v': expected %v, got %v", header, expected, accepts)
+		}
+	}
+}
+
+func TestGzipHandler(t *testing.T) {
+	h := gzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Header().Get("Content-Encoding") != gzipEncoding {
+		t.Fatalf("expected a gzipped response, got headers %v", w.Header())
+	}
+	body, err := gunzipLimited(w.Body, 100)
+	if err != nil || string(body) != "hello" {
+		t.Errorf("expected hello, got %s (%v)", body, err)
+	}
+}
+
+func TestInsertTaskGzip(t *testing.T) {
+	withMemoryStore(t, nil, func() {
+		r := httptest.NewRequest("POST", "/data/perf", bytes.NewReader(gzipped(t, `{"ops": 1}`)))
+		r.Header.Set("Content-Encoding", gzipEncoding)
+		plugin.SetTask(r, seriesTask("t1", 1))
+		if w := serveRoute("/data/{name}", insertTask, r); w.Code != http.StatusOK {
+			t.Fatalf("expected a gzipped body to be stored, got %v: %v", w.Code, w.Body.String())
+		}
+		doc, err := jsonStore.FindByTaskId("t1", "perf")
+		if err != nil || doc == nil || !reflect.DeepEqual(doc.Data, map[string]interface{}{"ops": 1.0}) {
+			t.Errorf("expected the decompressed data, got %+v (%v)", doc, err)
+		}
+
+		settings = Settings{MaxPayloadBytes: 100}
+		r = httptest.NewRequest("POST", "/data/perf", bytes.NewReader(gzipped(t, `"`+strings.Repeat("x", 1000)+`"`)))
+		r.Header.Set("Content-Encoding", gzipEncoding)
+		plugin.SetTask(r, seriesTask("t1", 1))
+		if w := serveRoute("/data/{name}", insertTask, r); w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("expected 413 for data over the limit once decompressed, got %v", w.Code)
+		}
+	})
+}
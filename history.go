@@ -1,72 +1,241 @@
 package evgjson
 
 import (
+	"fmt"
 	"github.com/evergreen-ci/evergreen"
-	"github.com/evergreen-ci/evergreen/db"
 	"github.com/evergreen-ci/evergreen/model/task"
 	"github.com/evergreen-ci/evergreen/plugin"
 	"github.com/gorilla/mux"
-	"gopkg.in/mgo.v2/bson"
 	"net/http"
+	"strconv"
+	"time"
 )
 
+const (
+	// defaultHistoryWindow is the number of documents returned on each side
+	// of a task's revision when no window is given.
+	defaultHistoryWindow = 100
+	// maxHistoryPageSize caps both the window and the size of a page.
+	maxHistoryPageSize = 1000
+	// nextCursorHeader is set on a page of history that is followed by
+	// another page. Its value is passed back as the "cursor" parameter.
+	nextCursorHeader = "X-Next-Cursor"
+)
+
+// rangeParams are the query parameters that select a page of history
+// instead of a window around the task's revision.
+var rangeParams = []string{"from_order", "to_order", "start", "end", "cursor", "limit"}
+
+// intParam reads an integer query parameter, returning def if it is not set.
+func intParam(r *http.Request, name string, def int) (int, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %v '%v'", name, value)
+	}
+	return i, nil
+}
+
+// timeParam reads an RFC 3339 time query parameter, returning the zero time if it is not set.
+func timeParam(r *http.Request, name string) (time.Time, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %v '%v': must be an RFC 3339 time", name, value)
+	}
+	return t, nil
+}
+
+// parseHistoryRange reads the range parameters of a history request.
+func parseHistoryRange(r *http.Request, key SeriesKey) (*HistoryRange, error) {
+	q := &HistoryRange{SeriesKey: key, Fields: fieldsParam(r)}
+	var err error
+	if q.FromOrder, err = intParam(r, "from_order", 0); err != nil {
+		return nil, err
+	}
+	if q.ToOrder, err = intParam(r, "to_order", 0); err != nil {
+		return nil, err
+	}
+	if q.AfterOrder, err = intParam(r, "cursor", 0); err != nil {
+		return nil, err
+	}
+	if q.Limit, err = intParam(r, "limit", defaultHistoryWindow); err != nil {
+		return nil, err
+	}
+	if q.Limit <= 0 || q.Limit > maxHistoryPageSize {
+		return nil, fmt.Errorf("limit must be between 1 and %v", maxHistoryPageSize)
+	}
+	if q.Start, err = timeParam(r, "start"); err != nil {
+		return nil, err
+	}
+	if q.End, err = timeParam(r, "end"); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// getTaskHistory sends back the history of the task's document. By default
+// this is a window of documents on each side of the task's revision, whose
+// size is set by the "window" parameter. If any of "from_order", "to_order",
+// "start", "end", "cursor" or "limit" are given, it instead sends back one
+// page of the documents in that range, in revision order, and sets the
+// nextCursorHeader if there are more; a range can't be asked for in the
+// history of a patch task. The "format" parameter may ask for the
+// documents as a table of comma or tab separated values, and the Accept
+// header for them to be streamed as newline delimited json; either way
+// they are read from the store as they are sent.
 func getTaskHistory(t *task.Task, w http.ResponseWriter, r *http.Request) {
+	key := SeriesKey{
+		ProjectId: t.Project,
+		Variant:   t.BuildVariant,
+		TaskName:  t.DisplayName,
+		Name:      mux.Vars(r)["name"],
+	}
+	format, err := tableFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, param := range rangeParams {
+		if r.FormValue(param) == "" {
+			continue
+		}
+		// a patch's history is only ever the window around its base commit
+		if t.Requester == evergreen.PatchVersionRequester {
+			http.Error(w, fmt.Sprintf("'%v' can't be used for the history of a patch task", param),
+				http.StatusBadRequest)
+			return
+		}
+		getTaskHistoryPage(key, format, w, r)
+		return
+	}
+
+	window, err := intParam(r, "window", defaultHistoryWindow)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if window <= 0 || window > maxHistoryPageSize {
+		http.Error(w, fmt.Sprintf("window must be between 1 and %v", maxHistoryPageSize), http.StatusBadRequest)
+		return
+	}
+
 	var t2 *task.Task = t
-	var err error
 	if t.Requester == evergreen.PatchVersionRequester {
 		t2, err = t.FindTaskOnBaseCommit()
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if t2 == nil {
+			http.Error(w, "no task found on the base commit", http.StatusNotFound)
+			return
+		}
 		t.RevisionOrderNumber = t2.RevisionOrderNumber
 	}
 
-	before := []TaskJSON{}
-	jsonQuery := db.Query(bson.M{
-		ProjectIdKey:           t.Project,
-		VariantKey:             t.BuildVariant,
-		RevisionOrderNumberKey: bson.M{"$lte": t.RevisionOrderNumber},
-		TaskNameKey:            t.DisplayName,
-		IsPatchKey:             false,
-		NameKey:                mux.Vars(r)["name"]})
-	jsonQuery = jsonQuery.Sort([]string{"-order"}).Limit(100)
-	err = db.FindAllQ(collection, jsonQuery, &before)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	q := HistoryQuery{
+		SeriesKey: key,
+		Order:     t.RevisionOrderNumber,
+		Before:    window,
+		After:     window,
+		Fields:    fieldsParam(r),
+	}
+	iter := func(each func(*TaskJSON) error) error {
+		if t.Requester == evergreen.PatchVersionRequester {
+			return iterPatchHistory(t.Id, key.Name, t2, q, each)
+		}
+		return jsonStore.IterHistory(q, each)
+	}
+	if format != "" {
+		writeTable(w, format, http.StatusOK, iter)
 		return
 	}
-	//reverse order of "before" because we had to sort it backwards to apply the limit correctly:
-	for i, j := 0, len(before)-1; i < j; i, j = i+1, j-1 {
-		before[i], before[j] = before[j], before[i]
+	if wantsNDJSON(r) {
+		streamDocs(w, http.StatusOK, iter)
+		return
 	}
-
-	after := []TaskJSON{}
-	jsonAfterQuery := db.Query(bson.M{
-		ProjectIdKey:           t.Project,
-		VariantKey:             t.BuildVariant,
-		RevisionOrderNumberKey: bson.M{"$gt": t.RevisionOrderNumber},
-		TaskNameKey:            t.DisplayName,
-		IsPatchKey:             false,
-		NameKey:                mux.Vars(r)["name"]}).Sort([]string{"order"}).Limit(100)
-	err = db.FindAllQ(collection, jsonAfterQuery, &after)
+	history, err := jsonStore.FindHistory(q)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	//concatenate before + after
-	before = append(before, after...)
-
 	// if our task was a patch, replace the base commit's info in the history with the patch
 	if t.Requester == evergreen.PatchVersionRequester {
-		before, err = fixPatchInHistory(t.Id, t2, before)
+		history, err = fixPatchInHistory(t.Id, mux.Vars(r)["name"], t2, history, fieldsParam(r))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	}
-	plugin.WriteJSON(w, http.StatusOK, before)
+	plugin.WriteJSON(w, http.StatusOK, history)
+}
+
+// getTaskHistoryPage sends back a page of a series' history, as json or
+// as a table in a format from tableFormat.
+func getTaskHistoryPage(key SeriesKey, format string, w http.ResponseWriter, r *http.Request) {
+	q, err := parseHistoryRange(r, key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// fetch one extra document to find out whether there is another page
+	pageSize := q.Limit
+	q.Limit++
+	more, last := false, 0
+	iter := func(each func(*TaskJSON) error) error {
+		sent := 0
+		return jsonStore.IterHistoryRange(*q, func(doc *TaskJSON) error {
+			if sent == pageSize {
+				more = true
+				return nil
+			}
+			sent++
+			last = doc.RevisionOrderNumber
+			return each(doc)
+		})
+	}
+	if format != "" {
+		// the columns are found before the table is sent, and with them
+		// whether there is another page
+		paths, _, err := tableColumns(iter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if more {
+			w.Header().Set(nextCursorHeader, strconv.Itoa(last))
+		}
+		writeTableRows(w, format, paths, iter)
+		return
+	}
+	if wantsNDJSON(r) {
+		// whether there is another page is only known once this one is
+		// sent, so the cursor comes in a trailer
+		w.Header().Set("Trailer", nextCursorHeader)
+		streamDocs(w, http.StatusOK, iter)
+		if more {
+			w.Header().Set(nextCursorHeader, strconv.Itoa(last))
+		}
+		return
+	}
+	page, err := jsonStore.FindHistoryRange(*q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(page) > pageSize {
+		page = page[:pageSize]
+		w.Header().Set(nextCursorHeader, strconv.Itoa(page[pageSize-1].RevisionOrderNumber))
+	}
+	plugin.WriteJSON(w, http.StatusOK, page)
 }
 
 // getTaskHistory finds previous tasks by task name.
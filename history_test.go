@@ -0,0 +1,97 @@
+package evgjson
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/evergreen-ci/evergreen"
+)
+
+// historyDocs returns the documents of a series at orders 1 to 5.
+func historyDocs() []TaskJSON {
+	docs := []TaskJSON{}
+	for order := 1; order <= 5; order++ {
+		docs = append(docs, seriesDoc(fmt.Sprintf("t%v", order), order, map[string]interface{}{"ops": float64(order)}))
+	}
+	return docs
+}
+
+func TestGetTaskHistoryWindow(t *testing.T) {
+	withMemoryStore(t, historyDocs(), func() {
+		handler := forTask(seriesTask("t3", 3), getTaskHistory)
+		w := serveRoute("/history/{name}", handler, newRequest("GET", "/history/perf?window=2", nil))
+		history := []TaskJSON{}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &history) != nil {
+			t.Fatalf("expected history, got %v: %v", w.Code, w.Body.String())
+		}
+		if !reflect.DeepEqual(orders(history), []int{2, 3, 4, 5}) {
+			t.Errorf("expected orders 2 to 5, got %v", orders(history))
+		}
+
+		for _, query := range []string{"window=0", "window=x", "window=1001"} {
+			w = serveRoute("/history/{name}", handler, newRequest("GET", "/history/perf?"+query, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%v: expected 400, got %v", query, w.Code)
+			}
+		}
+	})
+}
+
+func TestGetTaskHistoryPages(t *testing.T) {
+	withMemoryStore(t, historyDocs(), func() {
+		handler := forTask(seriesTask("t3", 3), getTaskHistory)
+		cursors := []string{}
+		pages := [][]int{}
+		url := "/history/perf?limit=2"
+		for len(pages) < 5 {
+			w := serveRoute("/history/{name}", handler, newRequest("GET", url, nil))
+			page := []TaskJSON{}
+			if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &page) != nil {
+				t.Fatalf("expected a page, got %v: %v", w.Code, w.Body.String())
+			}
+			pages = append(pages, orders(page))
+			cursor := w.Header().Get(nextCursorHeader)
+			if cursor == "" {
+				break
+			}
+			cursors = append(cursors, cursor)
+			url = "/history/perf?limit=2&cursor=" + cursor
+		}
+		if !reflect.DeepEqual(pages, [][]int{{1, 2}, {3, 4}, {5}}) {
+			t.Errorf("expected three pages, got %v", pages)
+		}
+		if !reflect.DeepEqual(cursors, []string{"2", "4"}) {
+			t.Errorf("expected cursors 2 and 4, got %v", cursors)
+		}
+
+		w := serveRoute("/history/{name}", handler, newRequest("GET", "/history/perf?from_order=2&to_order=4", nil))
+		page := []TaskJSON{}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &page) != nil {
+			t.Fatalf("expected a page, got %v: %v", w.Code, w.Body.String())
+		}
+		if !reflect.DeepEqual(orders(page), []int{2, 3, 4}) || w.Header().Get(nextCursorHeader) != "" {
+			t.Errorf("expected orders 2 to 4 with no cursor, got %v", orders(page))
+		}
+
+		for _, query := range []string{"limit=0", "limit=1001", "cursor=x", "start=yesterday"} {
+			w = serveRoute("/history/{name}", handler, newRequest("GET", "/history/perf?"+query, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%v: expected 400, got %v", query, w.Code)
+			}
+		}
+	})
+}
+
+func TestGetTaskHistoryPatchRange(t *testing.T) {
+	withMemoryStore(t, historyDocs(), func() {
+		patch := seriesTask("patch", 3)
+		patch.Requester = evergreen.PatchVersionRequester
+		w := serveRoute("/history/{name}", forTask(patch, getTaskHistory), newRequest("GET", "/history/perf?limit=2", nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for a range of a patch's history, got %v", w.Code)
+		}
+	})
+}
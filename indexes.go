@@ -0,0 +1,142 @@
+package evgjson
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/10gen-labs/slogger/v1"
+	"github.com/evergreen-ci/evergreen"
+	"github.com/evergreen-ci/evergreen/plugin"
+)
+
+// IndexSpec is an index the plugin's queries rely on.
+type IndexSpec struct {
+	Collection string   `json:"collection"`
+	Key        []string `json:"key"`
+	Unique     bool     `json:"unique"`
+	// Routes are the routes whose queries scan the whole collection
+	// without the index.
+	Routes []string `json:"routes"`
+}
+
+// IndexStatus says whether an index the plugin relies on exists.
+type IndexStatus struct {
+	IndexSpec
+	Present bool `json:"present"`
+}
+
+// requiredIndexes are the indexes for each shape of query the plugin runs.
+var requiredIndexes = []IndexSpec{
+	{
+		Collection: collection,
+		Key:        []string{TaskIdKey, NameKey, ExecutionKey},
+		// merges and appends rely on it to create a document only once
+		Unique: true,
+		Routes: []string{"/data/{name}", "/task/{task_id}/{name}/", "/diff/{task_id_a}/{task_id_b}/{name}",
+			"/compare/{task_id}/{name}", "/regression/{task_id}/{name}"},
+	},
+	{
+		Collection: collection,
+		Key:        []string{VersionIdKey, NameKey},
+		Routes: []string{"/version/{version_id}/{name}/", "/version/{version_id}/{name}/matrix",
+			"/task/{task_id}/{name}/tag"},
+	},
+	{
+		Collection: collection,
+		Key:        []string{ProjectIdKey, VariantKey, TaskNameKey, NameKey, RevisionOrderNumberKey},
+		Routes: []string{"/history/{task_id}/{name}", "/series/{project_id}/{variant}/{task_name}/{name}",
+			"/commit/{project_id}/{revision}/{variant}/{task_name}/{name}",
+			"/tag/{project_id}/{tag}/{variant}/{task_name}/{name}", "/regression/{task_id}/{name}"},
+	},
+	{
+		Collection: collection,
+		Key:        []string{ProjectIdKey, NameKey, RevisionOrderNumberKey},
+		Routes:     []string{"/version/latest/{name}/", "/metrics/{project_id}"},
+	},
+	{
+		Collection: collection,
+		Key:        []string{ProjectIdKey, TagsKey + "." + TagInfoNameKey},
+		Routes:     []string{"/task/{task_id}/{name}/tags", "/task/{task_id}/{name}/tag"},
+	},
+	{
+		Collection: collection,
+		Key:        []string{ProjectIdKey, IsPatchKey, CreateTimeKey},
+		Routes:     []string{"/retention/{project_id}"},
+	},
+	{
+		Collection: tagHistoryCollection,
+		Key:        []string{TagEventProjectIdKey, "-" + TagEventCreateTimeKey},
+		Routes:     []string{"/tags/{project_id}/history"},
+	},
+	{
+		Collection: baselineCollection,
+		Key:        []string{BaselineProjectIdKey, BaselineVariantKey, BaselineTaskNameKey, BaselineNameKey},
+		Unique:     true,
+		Routes:     []string{"/baseline/{project_id}/{variant}/{task_name}/{name}", "/compare/{task_id}/{name}/baseline"},
+	},
+	{
+		Collection: schemaCollection,
+		Key:        []string{SchemaProjectIdKey, SchemaNameKey},
+		Unique:     true,
+		Routes:     []string{"/data/{name}", "/schema/{project_id}/{name}"},
+	},
+}
+
+// indexStatus reports which of the required indexes exist.
+func indexStatus() ([]IndexStatus, error) {
+	existing := map[string]map[string]bool{}
+	statuses := make([]IndexStatus, 0, len(requiredIndexes))
+	for _, spec := range requiredIndexes {
+		if _, ok := existing[spec.Collection]; !ok {
+			keys, err := jsonStore.ListIndexes(spec.Collection)
+			if err != nil {
+				return nil, err
+			}
+			existing[spec.Collection] = map[string]bool{}
+			for _, key := range keys {
+				existing[spec.Collection][strings.Join(key, ",")] = true
+			}
+		}
+		statuses = append(statuses, IndexStatus{
+			IndexSpec: spec,
+			Present:   existing[spec.Collection][strings.Join(spec.Key, ",")],
+		})
+	}
+	return statuses, nil
+}
+
+// ensureIndexes creates the required indexes that do not exist yet.
+func ensureIndexes() error {
+	statuses, err := indexStatus()
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		if status.Present {
+			continue
+		}
+		if err = jsonStore.EnsureIndex(status.IndexSpec); err != nil {
+			return err
+		}
+		evergreen.Logger.Logf(slogger.INFO, "Created index %v on collection '%v'",
+			strings.Join(status.Key, ","), status.Collection)
+	}
+	return nil
+}
+
+// getIndexStatus sends back the status of each index the plugin relies on,
+// and the routes that scan a whole collection without it. Only admins may
+// see it.
+func getIndexStatus(w http.ResponseWriter, r *http.Request) {
+	u := plugin.GetUser(r)
+	if u == nil || !isAdmin("", u.Username()) {
+		http.Error(w, "only an admin may view index status", http.StatusForbidden)
+		return
+	}
+	statuses, err := indexStatus()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	plugin.WriteJSON(w, http.StatusOK, statuses)
+}
@@ -1,9 +1,10 @@
 package evgjson
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/10gen-labs/slogger/v1"
-	"github.com/evergreen-ci/evergreen/db"
 	"github.com/evergreen-ci/evergreen/db/bsonutil"
 	"github.com/evergreen-ci/evergreen/model"
 	"github.com/evergreen-ci/evergreen/model/task"
@@ -11,15 +12,25 @@ import (
 	"github.com/evergreen-ci/evergreen/util"
 	"github.com/gorilla/mux"
 	"github.com/mitchellh/mapstructure"
+	"github.com/xeipuuv/gojsonschema"
 	"gopkg.in/mgo.v2/bson"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
 	"time"
 )
 
-const collection = "json"
+const (
+	collection           = "json"
+	schemaCollection     = "json_schemas"
+	tagHistoryCollection = "json_tag_history"
+	baselineCollection   = "json_baselines"
+)
 
 func init() {
 	plugin.Publish(&JSONPlugin{})
@@ -35,19 +46,31 @@ func (jsp *JSONPlugin) Name() string {
 }
 
 type TaskJSON struct {
-	Name                string                 `bson:"name" json:"name"`
-	TaskName            string                 `bson:"task_name" json:"task_name"`
-	ProjectId           string                 `bson:"project_id" json:"project_id"`
-	TaskId              string                 `bson:"task_id" json:"task_id"`
-	BuildId             string                 `bson:"build_id" json:"build_id"`
-	Variant             string                 `bson:"variant" json:"variant"`
-	VersionId           string                 `bson:"version_id" json:"version_id"`
-	CreateTime          time.Time              `bson:"create_time" json:"create_time"`
-	IsPatch             bool                   `bson:"is_patch" json:"is_patch"`
-	RevisionOrderNumber int                    `bson:"order" json:"order"`
-	Revision            string                 `bson:"revision" json:"revision"`
-	Data                map[string]interface{} `bson:"data" json:"data"`
-	Tag                 string                 `bson:"tag" json:"tag"`
+	Name                string      `bson:"name" json:"name"`
+	TaskName            string      `bson:"task_name" json:"task_name"`
+	ProjectId           string      `bson:"project_id" json:"project_id"`
+	TaskId              string      `bson:"task_id" json:"task_id"`
+	BuildId             string      `bson:"build_id" json:"build_id"`
+	Variant             string      `bson:"variant" json:"variant"`
+	VersionId           string      `bson:"version_id" json:"version_id"`
+	CreateTime          time.Time   `bson:"create_time" json:"create_time"`
+	IsPatch             bool        `bson:"is_patch" json:"is_patch"`
+	RevisionOrderNumber int         `bson:"order" json:"order"`
+	Revision            string      `bson:"revision" json:"revision"`
+	Data                interface{} `bson:"data" json:"data"`
+	Tags                []TagInfo   `bson:"tags,omitempty" json:"tags"`
+	// Execution is the execution of the task that stored the document.
+	Execution int `bson:"execution" json:"execution"`
+	// Superseded is set once a later execution of the task stores a
+	// document under the same name. Only the latest execution's document
+	// is returned unless an execution is asked for.
+	Superseded bool `bson:"superseded,omitempty" json:"superseded,omitempty"`
+	// DataFile names the GridFS file holding Data when it was too large to
+	// store in the document itself.
+	DataFile string `bson:"data_file,omitempty" json:"-"`
+	// WriteId changes on every write, so that an update can tell whether
+	// the document changed after it was read.
+	WriteId bson.ObjectId `bson:"write_id,omitempty" json:"-"`
 }
 
 var (
@@ -64,7 +87,16 @@ var (
 	RevisionOrderNumberKey = bsonutil.MustHaveTag(TaskJSON{}, "RevisionOrderNumber")
 	RevisionKey            = bsonutil.MustHaveTag(TaskJSON{}, "Revision")
 	DataKey                = bsonutil.MustHaveTag(TaskJSON{}, "Data")
-	TagKey                 = bsonutil.MustHaveTag(TaskJSON{}, "Tag")
+	TagsKey                = bsonutil.MustHaveTag(TaskJSON{}, "Tags")
+	DataFileKey            = bsonutil.MustHaveTag(TaskJSON{}, "DataFile")
+	ExecutionKey           = bsonutil.MustHaveTag(TaskJSON{}, "Execution")
+	SupersededKey          = bsonutil.MustHaveTag(TaskJSON{}, "Superseded")
+	WriteIdKey             = bsonutil.MustHaveTag(TaskJSON{}, "WriteId")
+
+	// metadataKeys are all the keys of a TaskJSON other than its data.
+	metadataKeys = []string{NameKey, TaskNameKey, ProjectIdKey, TaskIdKey, BuildIdKey, VariantKey,
+		VersionIdKey, CreateTimeKey, IsPatchKey, RevisionOrderNumberKey, RevisionKey, TagsKey, DataFileKey,
+		ExecutionKey, SupersededKey, WriteIdKey}
 )
 
 // GetRoutes returns an API route for serving patch data.
@@ -72,11 +104,14 @@ func (jsp *JSONPlugin) GetAPIHandler() http.Handler {
 	r := mux.NewRouter()
 	r.HandleFunc("/tags/{task_name}/{name}", getTaskByTag)
 	r.HandleFunc("/history/{task_name}/{name}", apiGetTaskHistory)
+	r.HandleFunc("/regression/{name}", apiGetRegressions)
+	r.HandleFunc("/compare/{name}", apiComparePatchToBase)
+	r.HandleFunc("/compare/{name}/baseline", apiCompareToBaseline)
 
 	r.HandleFunc("/data/{name}", insertTask)
 	r.HandleFunc("/data/{task_name}/{name}", getTaskByName)
 	r.HandleFunc("/data/{task_name}/{name}/{variant}", getTaskForVariant)
-	return r
+	return gzipHandler(r)
 }
 
 func (hwp *JSONPlugin) GetUIHandler() http.Handler {
@@ -85,6 +120,7 @@ func (hwp *JSONPlugin) GetUIHandler() http.Handler {
 	// version routes
 	r.HandleFunc("/version", getVersion)
 	r.HandleFunc("/version/{version_id}/{name}/", getTasksForVersion)
+	r.HandleFunc("/version/{version_id}/{name}/matrix", getVersionMatrix)
 	r.HandleFunc("/version/latest/{name}/", getTasksForLatestVersion)
 
 	// task routes
@@ -93,23 +129,31 @@ func (hwp *JSONPlugin) GetUIHandler() http.Handler {
 	r.HandleFunc("/task/{task_id}/{name}/tag", handleTaskTag)
 
 	r.HandleFunc("/tag/{project_id}/{tag}/{variant}/{task_name}/{name}", getTaskJSONByTag)
+	r.HandleFunc("/tags/{project_id}/history", getTagHistory)
 	r.HandleFunc("/commit/{project_id}/{revision}/{variant}/{task_name}/{name}", getCommit)
 	r.HandleFunc("/history/{task_id}/{name}", uiGetTaskHistory)
-	return r
+	r.HandleFunc("/regression/{task_id}/{name}", uiGetRegressions)
+	r.HandleFunc("/diff/{task_id_a}/{task_id_b}/{name}", getDiff)
+	r.HandleFunc("/compare/{task_id}/{name}", uiComparePatchToBase)
+	r.HandleFunc("/compare/{task_id}/{name}/baseline", uiCompareToBaseline)
+	r.HandleFunc("/baseline/{project_id}/{variant}/{task_name}/{name}", handleBaseline)
+	r.HandleFunc("/series/{project_id}/{variant}/{task_name}/{name}", getSeries)
+	r.HandleFunc("/schema/{project_id}/{name}", handleSchema)
+	r.HandleFunc("/retention/{project_id}", getRetentionReport)
+	r.HandleFunc("/metrics/{project_id}", getMetrics)
+	r.HandleFunc("/admin/indexes", getIndexStatus)
+	return gzipHandler(r)
 }
 
-func fixPatchInHistory(taskId string, base *task.Task, history []TaskJSON) ([]TaskJSON, error) {
-	var jsonForTask *TaskJSON
-	err := db.FindOneQ(collection, db.Query(bson.M{"task_id": taskId}), &jsonForTask)
+func fixPatchInHistory(taskId, name string, base *task.Task, history []TaskJSON, fields []string) ([]TaskJSON, error) {
+	jsonForTask, err := jsonStore.FindByTaskId(taskId, name, fields...)
 	if err != nil {
 		return nil, err
 	}
-	if base != nil {
-		jsonForTask.RevisionOrderNumber = base.RevisionOrderNumber
-	}
 	if jsonForTask == nil {
 		return history, nil
 	}
+	jsonForTask.RevisionOrderNumber = base.RevisionOrderNumber
 
 	found := false
 	for i, item := range history {
@@ -126,7 +170,50 @@ func fixPatchInHistory(taskId string, base *task.Task, history []TaskJSON) ([]Ta
 	return history, nil
 }
 
-func (jsp *JSONPlugin) Configure(map[string]interface{}) error {
+// iterPatchHistory is fixPatchInHistory for a history that is streamed:
+// it passes the documents of a history window to each, with the patch
+// task's document in place of its base commit's.
+func iterPatchHistory(taskId, name string, base *task.Task, q HistoryQuery, each func(*TaskJSON) error) error {
+	jsonForTask, err := jsonStore.FindByTaskId(taskId, name, q.Fields...)
+	if err != nil {
+		return err
+	}
+	if jsonForTask == nil {
+		return jsonStore.IterHistory(q, each)
+	}
+	jsonForTask.RevisionOrderNumber = base.RevisionOrderNumber
+
+	found := false
+	err = jsonStore.IterHistory(q, func(doc *TaskJSON) error {
+		if doc.Revision == base.Revision {
+			found = true
+			return each(jsonForTask)
+		}
+		return each(doc)
+	})
+	if err != nil || found {
+		return err
+	}
+	return each(jsonForTask)
+}
+
+// Configure reads the plugin's settings and prepares its database.
+func (jsp *JSONPlugin) Configure(conf map[string]interface{}) error {
+	s, err := parseSettings(conf)
+	if err != nil {
+		return err
+	}
+	settings = s
+	if err = ensureIndexes(); err != nil {
+		return err
+	}
+	// documents from before tags had metadata carry bare tag names
+	if err = jsonStore.MigrateTags(); err != nil {
+		return err
+	}
+	if settings.hasRetention() {
+		startPruning()
+	}
 	return nil
 }
 
@@ -157,13 +244,45 @@ func (jsp *JSONPlugin) NewCommand(cmdName string) (plugin.Command, error) {
 		return &JSONGetCommand{}, nil
 	} else if cmdName == "get_history" {
 		return &JSONHistoryCommand{}, nil
+	} else if cmdName == "check_regression" {
+		return &JSONCheckRegressionCommand{}, nil
 	}
 	return nil, &plugin.ErrUnknownCommand{cmdName}
 }
 
+// JSONSendCommand posts one or more JSON files to the API server. Files
+// are given by 'file' and/or 'files', each of which may be a glob pattern
+// relative to the working directory. A single file is stored under 'name';
+// when several files are sent, 'name_template' derives each document's
+// name from its file, e.g. "{{.Base}}" for the basename without extension.
+// If 'schema' names a JSON Schema file, each file is validated against it
+// before it is sent. 'mode' says how each file is combined with data the
+// task already stored under the same name: "replace" (the default),
+// "merge" or "append".
+//
+// Files are gzipped before they are sent. The agent can only post json and
+// can't set a Content-Encoding header, so the compressed file is sent as a
+// base64 string with "encoding=gzip" on the route. Other clients should
+// post a raw gzipped body with "Content-Encoding: gzip" instead.
 type JSONSendCommand struct {
-	File     string `mapstructure:"file" plugin:"expand"`
-	DataName string `mapstructure:"name" plugin:"expand"`
+	File         string   `mapstructure:"file" plugin:"expand"`
+	Files        []string `mapstructure:"files" plugin:"expand"`
+	DataName     string   `mapstructure:"name" plugin:"expand"`
+	NameTemplate string   `mapstructure:"name_template"`
+	SchemaFile   string   `mapstructure:"schema" plugin:"expand"`
+	Mode         string   `mapstructure:"mode"`
+}
+
+// sendFile holds the values available to a JSONSendCommand's name_template.
+type sendFile struct {
+	// Path is the file's path relative to the working directory.
+	Path string
+	// Dir is the name of the directory containing the file.
+	Dir string
+	// File is the file's name.
+	File string
+	// Base is the file's name without its extension.
+	Base string
 }
 
 func (jsc *JSONSendCommand) Name() string {
@@ -178,53 +297,211 @@ func (jsc *JSONSendCommand) ParseParams(params map[string]interface{}) error {
 	if err := mapstructure.Decode(params, jsc); err != nil {
 		return fmt.Errorf("error decoding '%v' params: %v", jsc.Name(), err)
 	}
-	return nil
+	if jsc.Mode == "" {
+		jsc.Mode = ReplaceMode
+	}
+	return validateMode(jsc.Mode)
 }
 
-func (jsc *JSONSendCommand) Execute(log plugin.Logger, com plugin.PluginCommunicator, conf *model.TaskConfig, stop chan bool) error {
-	if jsc.File == "" {
-		return fmt.Errorf("'file' param must not be blank")
+// resolveFiles expands the command's file patterns into the list of
+// matching files, relative to workDir.
+func (jsc *JSONSendCommand) resolveFiles(workDir string) ([]string, error) {
+	patterns := jsc.Files
+	if jsc.File != "" {
+		patterns = append([]string{jsc.File}, patterns...)
 	}
-	if jsc.DataName == "" {
-		return fmt.Errorf("'name' param must not be blank")
+	seen := map[string]bool{}
+	files := []string{}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(workDir, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid file pattern '%v': %v", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files matched '%v'", pattern)
+		}
+		for _, match := range matches {
+			rel, err := filepath.Rel(workDir, match)
+			if err != nil {
+				return nil, err
+			}
+			if !seen[rel] {
+				seen[rel] = true
+				files = append(files, rel)
+			}
+		}
 	}
+	return files, nil
+}
 
-	errChan := make(chan error)
-	go func() {
-		// attempt to open the file
-		fileLoc := filepath.Join(conf.WorkDir, jsc.File)
-		jsonFile, err := os.Open(fileLoc)
+// dataNames returns the name to send each file under.
+func (jsc *JSONSendCommand) dataNames(files []string) ([]string, error) {
+	if jsc.NameTemplate == "" {
+		if len(files) > 1 {
+			return nil, fmt.Errorf("'name_template' param must be set when sending %v files", len(files))
+		}
+		return []string{jsc.DataName}, nil
+	}
+
+	tmpl, err := template.New("name").Parse(jsc.NameTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid 'name_template': %v", err)
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		base := filepath.Base(file)
+		buf := &bytes.Buffer{}
+		err = tmpl.Execute(buf, sendFile{
+			Path: file,
+			Dir:  filepath.Base(filepath.Dir(file)),
+			File: base,
+			Base: strings.TrimSuffix(base, filepath.Ext(base)),
+		})
 		if err != nil {
-			errChan <- fmt.Errorf("Couldn't open json file: '%v'", err)
-			return
+			return nil, fmt.Errorf("error applying 'name_template' to '%v': %v", file, err)
 		}
+		name := buf.String()
+		if name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("'name_template' gave invalid name '%v' for '%v'", name, file)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
 
-		jsonData := map[string]interface{}{}
-		err = util.ReadJSONInto(jsonFile, &jsonData)
+// loadSchema reads and compiles the command's schema file, if it has one.
+func (jsc *JSONSendCommand) loadSchema(workDir string) (*gojsonschema.Schema, error) {
+	if jsc.SchemaFile == "" {
+		return nil, nil
+	}
+	schemaLoc := jsc.SchemaFile
+	if !filepath.IsAbs(schemaLoc) {
+		schemaLoc = filepath.Join(workDir, schemaLoc)
+	}
+	text, err := ioutil.ReadFile(schemaLoc)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't read schema file: '%v'", err)
+	}
+	schema, err := compileSchema(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("Schema file contained an invalid schema: %v", err)
+	}
+	return schema, nil
+}
+
+// postFile reads a JSON file and posts it under name, retrying on failure.
+// The file is checked against schema first, if it is not nil.
+func (jsc *JSONSendCommand) postFile(log plugin.Logger, com plugin.PluginCommunicator,
+	fileLoc, name string, schema *gojsonschema.Schema) error {
+	// attempt to open the file
+	jsonFile, err := os.Open(fileLoc)
+	if err != nil {
+		return fmt.Errorf("Couldn't open json file: '%v'", err)
+	}
+
+	var jsonData interface{}
+	err = util.ReadJSONInto(jsonFile, &jsonData)
+	if err != nil {
+		return fmt.Errorf("File contained invalid json: %v", err)
+	}
+	if jsonData == nil {
+		return fmt.Errorf("File contained null json")
+	}
+	if schema != nil {
+		violations, err := validateSchema(schema, jsonData)
 		if err != nil {
-			errChan <- fmt.Errorf("File contained invalid json: %v", err)
-			return
+			return fmt.Errorf("Couldn't validate json: %v", err)
+		}
+		if len(violations) > 0 {
+			return fmt.Errorf("File does not match schema:\n%v", strings.Join(violations, "\n"))
 		}
+	}
 
-		retriablePost := util.RetriableFunc(
-			func() error {
-				log.LogTask(slogger.INFO, "Posting JSON")
-				resp, err := com.TaskPostJSON(fmt.Sprintf("data/%v", jsc.DataName), jsonData)
-				if resp != nil {
-					defer resp.Body.Close()
-				}
-				if err != nil {
-					return util.RetriableError{err}
-				}
-				if resp.StatusCode != http.StatusOK {
-					return util.RetriableError{fmt.Errorf("unexpected status code %v", resp.StatusCode)}
-				}
-				return nil
-			},
-		)
+	// the communicator can't set a Content-Encoding, so the compressed
+	// data is posted as a string and the route is told how it's encoded
+	compressed, err := gzipData(jsonData)
+	if err != nil {
+		return fmt.Errorf("Couldn't compress json: %v", err)
+	}
+
+	retriablePost := util.RetriableFunc(
+		func() error {
+			log.LogTask(slogger.INFO, "Posting JSON from '%v' as '%v'", fileLoc, name)
+			resp, err := com.TaskPostJSON(fmt.Sprintf("data/%v?encoding=%v&mode=%v", name, gzipEncoding, jsc.Mode),
+				compressed)
+			if resp != nil {
+				defer resp.Body.Close()
+			}
+			if err != nil {
+				return util.RetriableError{err}
+			}
+			if resp.StatusCode == http.StatusRequestEntityTooLarge {
+				// sending it again will not make it any smaller
+				message, _ := ioutil.ReadAll(resp.Body)
+				return fmt.Errorf("'%v' is too large: %v", fileLoc, strings.TrimSpace(string(message)))
+			}
+			if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusConflict {
+				// nor will it make it acceptable, e.g. when it can't be merged
+				// or the data stored already can't be updated
+				message, _ := ioutil.ReadAll(resp.Body)
+				return fmt.Errorf("'%v' was rejected: %v", fileLoc, strings.TrimSpace(string(message)))
+			}
+			if resp.StatusCode != http.StatusOK {
+				return util.RetriableError{fmt.Errorf("unexpected status code %v", resp.StatusCode)}
+			}
+			return nil
+		},
+	)
+
+	_, err = util.Retry(retriablePost, 10, 3*time.Second)
+	return err
+}
+
+func (jsc *JSONSendCommand) Execute(log plugin.Logger, com plugin.PluginCommunicator, conf *model.TaskConfig, stop chan bool) error {
+	err := plugin.ExpandValues(jsc, conf.Expansions)
+	if err != nil {
+		return err
+	}
+
+	if jsc.File == "" && len(jsc.Files) == 0 {
+		return fmt.Errorf("'file' or 'files' param must not be blank")
+	}
+	if jsc.DataName == "" && jsc.NameTemplate == "" {
+		return fmt.Errorf("'name' param must not be blank")
+	}
+	if jsc.DataName != "" && jsc.NameTemplate != "" {
+		return fmt.Errorf("'name' and 'name_template' params must not both be set")
+	}
+
+	files, err := jsc.resolveFiles(conf.WorkDir)
+	if err != nil {
+		return err
+	}
+	names, err := jsc.dataNames(files)
+	if err != nil {
+		return err
+	}
+	schema, err := jsc.loadSchema(conf.WorkDir)
+	if err != nil {
+		return err
+	}
 
-		_, err = util.Retry(retriablePost, 10, 3*time.Second)
-		errChan <- err
+	errChan := make(chan error, 1)
+	go func() {
+		failures := []string{}
+		for i, file := range files {
+			err := jsc.postFile(log, com, filepath.Join(conf.WorkDir, file), names[i], schema)
+			if err != nil {
+				log.LogTask(slogger.ERROR, "Sending '%v' as '%v' failed: %v", file, names[i], err)
+				failures = append(failures, fmt.Sprintf("%v: %v", file, err))
+			}
+		}
+		if len(failures) > 0 {
+			errChan <- fmt.Errorf("failed to send %v of %v files:\n%v",
+				len(failures), len(files), strings.Join(failures, "\n"))
+			return
+		}
+		errChan <- nil
 	}()
 
 	select {
@@ -244,13 +521,28 @@ type JSONGetCommand struct {
 	DataName string `mapstructure:"name" plugin:"expand"`
 	TaskName string `mapstructure:"task" plugin:"expand"`
 	Variant  string `mapstructure:"variant" plugin:"expand"`
+	// Execution picks an execution of the task other than its latest. It
+	// is a string so that it may be set from an expansion.
+	Execution string `mapstructure:"execution" plugin:"expand"`
 }
 
+// JSONHistoryCommand writes the history of a task's document to a file.
+// By default this is the window of documents around the current revision,
+// sized by 'window'. Setting any of 'from_order', 'to_order', 'start',
+// 'end' or 'page_size' instead fetches every document in that range, one
+// page at a time; this fails for a patch task, whose history is always the
+// window around its base commit.
 type JSONHistoryCommand struct {
-	Tags     bool   `mapstructure:"tags"`
-	File     string `mapstructure:"file" plugin:"expand"`
-	DataName string `mapstructure:"name" plugin:"expand"`
-	TaskName string `mapstructure:"task" plugin:"expand"`
+	Tags      bool   `mapstructure:"tags"`
+	File      string `mapstructure:"file" plugin:"expand"`
+	DataName  string `mapstructure:"name" plugin:"expand"`
+	TaskName  string `mapstructure:"task" plugin:"expand"`
+	Window    int    `mapstructure:"window"`
+	FromOrder int    `mapstructure:"from_order"`
+	ToOrder   int    `mapstructure:"to_order"`
+	Start     string `mapstructure:"start" plugin:"expand"`
+	End       string `mapstructure:"end" plugin:"expand"`
+	PageSize  int    `mapstructure:"page_size"`
 }
 
 func (jgc *JSONGetCommand) Name() string {
@@ -305,6 +597,11 @@ func (jgc *JSONGetCommand) Execute(log plugin.Logger, com plugin.PluginCommunica
 	if jgc.TaskName == "" {
 		return fmt.Errorf("'task' param must not be blank")
 	}
+	if jgc.Execution != "" {
+		if execution, err := strconv.Atoi(jgc.Execution); err != nil || execution < 0 {
+			return fmt.Errorf("'execution' param must be a non-negative integer")
+		}
+	}
 
 	if jgc.File != "" && !filepath.IsAbs(jgc.File) {
 		jgc.File = filepath.Join(conf.WorkDir, jgc.File)
@@ -316,6 +613,9 @@ func (jgc *JSONGetCommand) Execute(log plugin.Logger, com plugin.PluginCommunica
 			if jgc.Variant != "" {
 				dataUrl = fmt.Sprintf("data/%s/%s/%s", jgc.TaskName, jgc.DataName, jgc.Variant)
 			}
+			if jgc.Execution != "" {
+				dataUrl += "?execution=" + jgc.Execution
+			}
 			resp, err := com.TaskGetJSON(dataUrl)
 			if resp != nil {
 				defer resp.Body.Close()
@@ -327,7 +627,7 @@ func (jgc *JSONGetCommand) Execute(log plugin.Logger, com plugin.PluginCommunica
 			}
 
 			if resp.StatusCode == http.StatusOK {
-				jsonBytes, err := ioutil.ReadAll(resp.Body)
+				jsonBytes, err := readResponse(resp)
 				if err != nil {
 					return err
 				}
@@ -346,6 +646,74 @@ func (jgc *JSONGetCommand) Execute(log plugin.Logger, com plugin.PluginCommunica
 	return err
 }
 
+// isRange returns whether the command fetches a range of history in pages.
+func (jgc *JSONHistoryCommand) isRange() bool {
+	return jgc.FromOrder != 0 || jgc.ToOrder != 0 || jgc.Start != "" || jgc.End != "" || jgc.PageSize != 0
+}
+
+// query returns the query parameters for the history route.
+func (jgc *JSONHistoryCommand) query() url.Values {
+	query := url.Values{}
+	if !jgc.isRange() {
+		if jgc.Window != 0 {
+			query.Set("window", strconv.Itoa(jgc.Window))
+		}
+		return query
+	}
+	if jgc.FromOrder != 0 {
+		query.Set("from_order", strconv.Itoa(jgc.FromOrder))
+	}
+	if jgc.ToOrder != 0 {
+		query.Set("to_order", strconv.Itoa(jgc.ToOrder))
+	}
+	if jgc.Start != "" {
+		query.Set("start", jgc.Start)
+	}
+	if jgc.End != "" {
+		query.Set("end", jgc.End)
+	}
+	if jgc.PageSize != 0 {
+		query.Set("limit", strconv.Itoa(jgc.PageSize))
+	}
+	return query
+}
+
+// fetch gets one response from the API server, returning its body and
+// the cursor for the page after it, if there is one.
+func (jgc *JSONHistoryCommand) fetch(log plugin.Logger, com plugin.PluginCommunicator, endpoint string) ([]byte, string, error) {
+	var jsonBytes []byte
+	var cursor string
+	retriableGet := util.RetriableFunc(
+		func() error {
+			resp, err := com.TaskGetJSON(endpoint)
+			if resp != nil {
+				defer resp.Body.Close()
+			}
+			if err != nil {
+				//Some generic error trying to connect - try again
+				log.LogExecution(slogger.WARN, "Error connecting to API server: %v", err)
+				return util.RetriableError{err}
+			}
+
+			if resp.StatusCode == http.StatusOK {
+				jsonBytes, err = readResponse(resp)
+				cursor = resp.Header.Get(nextCursorHeader)
+				return err
+			}
+			if resp.StatusCode == http.StatusNotFound {
+				return fmt.Errorf("No JSON data found")
+			}
+			if resp.StatusCode == http.StatusBadRequest {
+				msg, _ := readResponse(resp)
+				return fmt.Errorf("invalid history request: %s", msg)
+			}
+			return util.RetriableError{fmt.Errorf("unexpected status code %v", resp.StatusCode)}
+		},
+	)
+	_, err := util.Retry(retriableGet, 10, 3*time.Second)
+	return jsonBytes, cursor, err
+}
+
 func (jgc *JSONHistoryCommand) Execute(log plugin.Logger, com plugin.PluginCommunicator, conf *model.TaskConfig, stop chan bool) error {
 	err := plugin.ExpandValues(jgc, conf.Expansions)
 	if err != nil {
@@ -366,14 +734,104 @@ func (jgc *JSONHistoryCommand) Execute(log plugin.Logger, com plugin.PluginCommu
 		jgc.File = filepath.Join(conf.WorkDir, jgc.File)
 	}
 
-	endpoint := fmt.Sprintf("history/%s/%s", jgc.TaskName, jgc.DataName)
 	if jgc.Tags {
-		endpoint = fmt.Sprintf("tags/%s/%s", jgc.TaskName, jgc.DataName)
+		jsonBytes, _, err := jgc.fetch(log, com, fmt.Sprintf("tags/%s/%s", jgc.TaskName, jgc.DataName))
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(jgc.File, jsonBytes, 0755)
 	}
 
-	retriableGet := util.RetriableFunc(
+	endpoint := fmt.Sprintf("history/%s/%s", jgc.TaskName, jgc.DataName)
+	query := jgc.query()
+	if !jgc.isRange() {
+		if len(query) > 0 {
+			endpoint += "?" + query.Encode()
+		}
+		jsonBytes, _, err := jgc.fetch(log, com, endpoint)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(jgc.File, jsonBytes, 0755)
+	}
+
+	// follow the cursor from page to page, collecting every document
+	history := []json.RawMessage{}
+	for {
+		jsonBytes, cursor, err := jgc.fetch(log, com, endpoint+"?"+query.Encode())
+		if err != nil {
+			return err
+		}
+		page := []json.RawMessage{}
+		if err = json.Unmarshal(jsonBytes, &page); err != nil {
+			return fmt.Errorf("invalid history page: %v", err)
+		}
+		history = append(history, page...)
+		if cursor == "" {
+			break
+		}
+		log.LogExecution(slogger.INFO, "Fetched %v documents of history, continuing after order %v",
+			len(history), cursor)
+		query.Set("cursor", cursor)
+	}
+	jsonBytes, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(jgc.File, jsonBytes, 0755)
+}
+
+// JSONCheckRegressionCommand compares metrics in the document the task
+// sent under a name to the task's history, and fails if any of them has
+// regressed. The full verdict is written to 'file' if it is set.
+type JSONCheckRegressionCommand struct {
+	DataName string       `mapstructure:"name" plugin:"expand"`
+	File     string       `mapstructure:"file" plugin:"expand"`
+	Metrics  []MetricSpec `mapstructure:"metrics"`
+	Method   string       `mapstructure:"method"`
+	Window   int          `mapstructure:"window"`
+}
+
+func (jcc *JSONCheckRegressionCommand) Name() string {
+	return "check_regression"
+}
+
+func (jcc *JSONCheckRegressionCommand) Plugin() string {
+	return "json"
+}
+
+func (jcc *JSONCheckRegressionCommand) ParseParams(params map[string]interface{}) error {
+	if err := mapstructure.Decode(params, jcc); err != nil {
+		return fmt.Errorf("error decoding '%v' params: %v", jcc.Name(), err)
+	}
+	if len(jcc.Metrics) == 0 {
+		return fmt.Errorf("JSON 'check_regression' command must have at least one metric")
+	}
+	return nil
+}
+
+func (jcc *JSONCheckRegressionCommand) Execute(log plugin.Logger, com plugin.PluginCommunicator, conf *model.TaskConfig, stop chan bool) error {
+	err := plugin.ExpandValues(jcc, conf.Expansions)
+	if err != nil {
+		return err
+	}
+
+	if jcc.DataName == "" {
+		return fmt.Errorf("'name' param must not be blank")
+	}
+	req := &RegressionRequest{Metrics: jcc.Metrics, Method: jcc.Method, Window: jcc.Window}
+	if err = req.validate(); err != nil {
+		return err
+	}
+
+	if jcc.File != "" && !filepath.IsAbs(jcc.File) {
+		jcc.File = filepath.Join(conf.WorkDir, jcc.File)
+	}
+
+	report := &RegressionReport{}
+	retriablePost := util.RetriableFunc(
 		func() error {
-			resp, err := com.TaskGetJSON(endpoint)
+			resp, err := com.TaskPostJSON(fmt.Sprintf("regression/%s", jcc.DataName), req)
 			if resp != nil {
 				defer resp.Body.Close()
 			}
@@ -383,22 +841,50 @@ func (jgc *JSONHistoryCommand) Execute(log plugin.Logger, com plugin.PluginCommu
 				return util.RetriableError{err}
 			}
 
-			if resp.StatusCode == http.StatusOK {
-				jsonBytes, err := ioutil.ReadAll(resp.Body)
-				if err != nil {
-					return err
-				}
-				return ioutil.WriteFile(jgc.File, jsonBytes, 0755)
-			}
-			if resp.StatusCode != http.StatusOK {
-				if resp.StatusCode == http.StatusNotFound {
-					return fmt.Errorf("No JSON data found")
-				}
+			switch resp.StatusCode {
+			case http.StatusOK:
+				return util.ReadJSONInto(resp.Body, report)
+			case http.StatusNotFound:
+				return fmt.Errorf("No JSON data found")
+			case http.StatusBadRequest:
+				msg, _ := readResponse(resp)
+				return fmt.Errorf("invalid regression check: %s", msg)
+			default:
 				return util.RetriableError{fmt.Errorf("unexpected status code %v", resp.StatusCode)}
 			}
-			return nil
 		},
 	)
-	_, err = util.Retry(retriableGet, 10, 3*time.Second)
-	return err
+	_, err = util.Retry(retriablePost, 10, 3*time.Second)
+	if err != nil {
+		return err
+	}
+
+	if jcc.File != "" {
+		reportBytes, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(jcc.File, reportBytes, 0644); err != nil {
+			return err
+		}
+	}
+
+	regressed := []string{}
+	for _, m := range report.Metrics {
+		switch {
+		case m.Regressed:
+			log.LogTask(slogger.ERROR, "'%v' regressed: %v against a baseline of %v (%.2f deviations, threshold %v)",
+				m.Path, m.Value, m.Baseline, m.Score, m.Threshold)
+			regressed = append(regressed, m.Path)
+		case m.Message != "":
+			log.LogTask(slogger.WARN, "'%v' was not checked: %v", m.Path, m.Message)
+		default:
+			log.LogTask(slogger.INFO, "'%v' is within threshold: %v against a baseline of %v",
+				m.Path, m.Value, m.Baseline)
+		}
+	}
+	if len(regressed) > 0 {
+		return fmt.Errorf("'%v' regressed in: %v", jcc.DataName, strings.Join(regressed, ", "))
+	}
+	return nil
 }
@@ -0,0 +1,86 @@
+package evgjson
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResolveFiles(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "evg-json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workDir)
+	for _, file := range []string{"a.json", "b.json", "c.txt", "results/d.json"} {
+		if err = os.MkdirAll(filepath.Join(workDir, filepath.Dir(file)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(filepath.Join(workDir, file), []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		cmd      JSONSendCommand
+		expected []string
+	}{
+		{JSONSendCommand{File: "a.json"}, []string{"a.json"}},
+		{JSONSendCommand{Files: []string{"*.json", "results/*.json"}}, []string{"a.json", "b.json", "results/d.json"}},
+		// files matched more than once are only sent once
+		{JSONSendCommand{File: "b.json", Files: []string{"*.json"}}, []string{"b.json", "a.json"}},
+	}
+	for _, c := range cases {
+		files, err := c.cmd.resolveFiles(workDir)
+		if err != nil {
+			t.Errorf("%+v: %v", c.cmd, err)
+			continue
+		}
+		if !reflect.DeepEqual(files, c.expected) {
+			t.Errorf("%+v: expected %v, got %v", c.cmd, c.expected, files)
+		}
+	}
+
+	for _, cmd := range []JSONSendCommand{{File: "missing.json"}, {Files: []string{"*.json", "*.csv"}}, {File: "["}} {
+		if _, err = cmd.resolveFiles(workDir); err == nil {
+			t.Errorf("%+v: expected an error", cmd)
+		}
+	}
+}
+
+func TestDataNames(t *testing.T) {
+	files := []string{"a.json", "results/d.json"}
+	cases := []struct {
+		template string
+		expected []string
+	}{
+		{"{{.Base}}", []string{"a", "d"}},
+		{"{{.File}}", []string{"a.json", "d.json"}},
+		{"{{.Dir}}-{{.Base}}", []string{".-a", "results-d"}},
+	}
+	for _, c := range cases {
+		names, err := (&JSONSendCommand{NameTemplate: c.template}).dataNames(files)
+		if err != nil {
+			t.Errorf("%v: %v", c.template, err)
+			continue
+		}
+		if !reflect.DeepEqual(names, c.expected) {
+			t.Errorf("%v: expected %v, got %v", c.template, c.expected, names)
+		}
+	}
+
+	names, err := (&JSONSendCommand{DataName: "perf"}).dataNames(files[:1])
+	if err != nil || !reflect.DeepEqual(names, []string{"perf"}) {
+		t.Errorf("expected the name param for a single file, got %v (%v)", names, err)
+	}
+	if _, err = (&JSONSendCommand{DataName: "perf"}).dataNames(files); err == nil {
+		t.Errorf("expected an error for several files without a name_template")
+	}
+	for _, template := range []string{"{{.Path}}", "{{if false}}x{{end}}", "{{.Missing}}", "{{"} {
+		if _, err = (&JSONSendCommand{NameTemplate: template}).dataNames(files); err == nil {
+			t.Errorf("%v: expected an error", template)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package evgjson
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// Modes for storing a document under a name a task may already have
+	// stored data under.
+	//
+	// ReplaceMode overwrites the stored data.
+	ReplaceMode = "replace"
+	// MergeMode deep-merges an object into the stored data: fields of
+	// nested objects are merged, and any other value, including an array,
+	// replaces the value at the same path.
+	MergeMode = "merge"
+	// AppendMode adds the elements of an array, or any other single value,
+	// to the end of the stored array.
+	AppendMode = "append"
+)
+
+// validateMode checks that a mode is one of the known modes.
+func validateMode(mode string) error {
+	if mode != ReplaceMode && mode != MergeMode && mode != AppendMode {
+		return fmt.Errorf("unknown mode '%v': must be '%v', '%v' or '%v'", mode, ReplaceMode, MergeMode, AppendMode)
+	}
+	return nil
+}
+
+// combineData returns the data that storing incoming over existing in a
+// mode results in. existing is nil if nothing is stored yet.
+func combineData(mode string, existing, incoming interface{}) (interface{}, error) {
+	switch mode {
+	case MergeMode:
+		if _, ok := asMap(incoming); !ok {
+			return nil, fmt.Errorf("only an object can be merged")
+		}
+		if err := checkMergeKeys(incoming); err != nil {
+			return nil, err
+		}
+		if existing == nil {
+			return incoming, nil
+		}
+		if _, ok := asMap(existing); !ok {
+			return nil, fmt.Errorf("can't merge into data that is not an object")
+		}
+		return mergeData(existing, incoming), nil
+	case AppendMode:
+		elems := appendElems(incoming)
+		if existing == nil {
+			return elems, nil
+		}
+		existingArray, ok := existing.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("can't append to data that is not an array")
+		}
+		return append(append([]interface{}{}, existingArray...), elems...), nil
+	}
+	return incoming, nil
+}
+
+// checkMergeKeys checks the keys of an object to be merged, and of the
+// objects nested in it, since each may become part of a field path in the
+// database's update. A key may not be blank, hold a '.' or start with '$'.
+func checkMergeKeys(incoming interface{}) error {
+	m, ok := asMap(incoming)
+	if !ok {
+		return nil
+	}
+	for k, v := range m {
+		if k == "" || strings.Contains(k, ".") || strings.HasPrefix(k, "$") {
+			return fmt.Errorf("can't merge key '%v': keys must not be blank, contain '.' or start with '$'", k)
+		}
+		if err := checkMergeKeys(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeData deep-merges incoming into existing, without changing either.
+func mergeData(existing, incoming interface{}) interface{} {
+	existingMap, ok := asMap(existing)
+	if !ok {
+		return incoming
+	}
+	incomingMap, ok := asMap(incoming)
+	if !ok {
+		return incoming
+	}
+	merged := map[string]interface{}{}
+	for k, v := range existingMap {
+		merged[k] = v
+	}
+	for k, v := range incomingMap {
+		merged[k] = mergeData(merged[k], v)
+	}
+	return merged
+}
+
+// appendElems returns the values appending incoming adds: its elements if
+// it is an array, or else incoming itself.
+func appendElems(incoming interface{}) []interface{} {
+	if elems, ok := incoming.([]interface{}); ok {
+		return elems
+	}
+	return []interface{}{incoming}
+}
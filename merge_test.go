@@ -0,0 +1,164 @@
+package evgjson
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestMergeData(t *testing.T) {
+	existing := map[string]interface{}{
+		"a": map[string]interface{}{"x": 1.0, "y": []interface{}{1.0}},
+		"b": 2.0,
+	}
+	incoming := map[string]interface{}{
+		"a": map[string]interface{}{"y": []interface{}{2.0}, "z": 3.0},
+		"c": map[string]interface{}{},
+	}
+	expected := map[string]interface{}{
+		"a": map[string]interface{}{"x": 1.0, "y": []interface{}{2.0}, "z": 3.0},
+		"b": 2.0,
+		"c": map[string]interface{}{},
+	}
+	if merged := mergeData(existing, incoming); !reflect.DeepEqual(merged, expected) {
+		t.Errorf("expected %v, got %v", expected, merged)
+	}
+	// neither input is changed
+	if _, ok := existing["c"]; ok {
+		t.Errorf("existing data was changed: %v", existing)
+	}
+
+	// an empty object leaves an object already there as it is
+	merged := mergeData(existing, map[string]interface{}{"a": map[string]interface{}{}})
+	if !reflect.DeepEqual(merged, existing) {
+		t.Errorf("expected %v, got %v", existing, merged)
+	}
+}
+
+func TestCombineData(t *testing.T) {
+	object := map[string]interface{}{"a": 1.0}
+	array := []interface{}{1.0}
+	cases := []struct {
+		mode     string
+		existing interface{}
+		incoming interface{}
+		expected interface{}
+		fails    bool
+	}{
+		{ReplaceMode, object, array, array, false},
+		{MergeMode, nil, object, object, false},
+		{MergeMode, object, map[string]interface{}{"b": 2.0}, map[string]interface{}{"a": 1.0, "b": 2.0}, false},
+		{MergeMode, object, array, nil, true},
+		{MergeMode, array, object, nil, true},
+		// keys that can't be part of a field path can't be merged
+		{MergeMode, object, map[string]interface{}{"a.b": 1.0}, nil, true},
+		{MergeMode, nil, map[string]interface{}{"a": map[string]interface{}{"$set": 1.0}}, nil, true},
+		{MergeMode, object, map[string]interface{}{"": 1.0}, nil, true},
+		{AppendMode, nil, 1.0, []interface{}{1.0}, false},
+		{AppendMode, array, []interface{}{2.0, 3.0}, []interface{}{1.0, 2.0, 3.0}, false},
+		{AppendMode, array, object, []interface{}{1.0, object}, false},
+		{AppendMode, object, 1.0, nil, true},
+	}
+	for _, c := range cases {
+		combined, err := combineData(c.mode, c.existing, c.incoming)
+		if c.fails {
+			if err == nil {
+				t.Errorf("%v of %v into %v: expected an error", c.mode, c.incoming, c.existing)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v of %v into %v: %v", c.mode, c.incoming, c.existing, err)
+			continue
+		}
+		if !reflect.DeepEqual(combined, c.expected) {
+			t.Errorf("%v of %v into %v: expected %v, got %v", c.mode, c.incoming, c.existing, c.expected, combined)
+		}
+	}
+	// appending doesn't change the stored array
+	if len(array) != 1 {
+		t.Errorf("existing array was changed: %v", array)
+	}
+}
+
+func TestValidateMode(t *testing.T) {
+	for _, mode := range []string{ReplaceMode, MergeMode, AppendMode} {
+		if err := validateMode(mode); err != nil {
+			t.Errorf("mode '%v': %v", mode, err)
+		}
+	}
+	if err := validateMode("upsert"); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
+
+func TestMergeUpdates(t *testing.T) {
+	existing := map[string]interface{}{"a": map[string]interface{}{"x": 1.0}, "b": 2.0}
+	incoming := map[string]interface{}{
+		"a": map[string]interface{}{"y": 2.0},
+		"b": map[string]interface{}{"z": 3.0},
+		"c": []interface{}{4.0},
+	}
+	updates := bson.M{}
+	mergeUpdates(DataKey, incoming, existing, updates)
+	expected := bson.M{"data.a.y": 2.0, "data.b": map[string]interface{}{"z": 3.0}, "data.c": []interface{}{4.0}}
+	if !reflect.DeepEqual(updates, expected) {
+		t.Errorf("expected %v, got %v", expected, updates)
+	}
+}
+
+func TestInsertTaskModes(t *testing.T) {
+	withMemoryStore(t, nil, func() {
+		if w := sendData("t1", 1, "?mode=merge", map[string]interface{}{"a": map[string]interface{}{"x": 1.0}}); w.Code != http.StatusOK {
+			t.Fatalf("expected the data to be merged, got %v: %v", w.Code, w.Body.String())
+		}
+		if w := sendData("t1", 1, "?mode=merge", map[string]interface{}{"a": map[string]interface{}{"y": 2.0}}); w.Code != http.StatusOK {
+			t.Fatalf("expected the data to be merged, got %v: %v", w.Code, w.Body.String())
+		}
+		doc, _ := jsonStore.FindByTaskId("t1", "perf")
+		expected := map[string]interface{}{"a": map[string]interface{}{"x": 1.0, "y": 2.0}}
+		if doc == nil || !reflect.DeepEqual(doc.Data, expected) {
+			t.Errorf("expected %v, got %+v", expected, doc)
+		}
+
+		for query, data := range map[string]interface{}{
+			"?mode=merge":  map[string]interface{}{"a": map[string]interface{}{"b.c": 1.0}},
+			"?mode=append": 1.0,
+			"?mode=upsert": 1.0,
+		} {
+			if w := sendData("t1", 1, query, data); w.Code != http.StatusBadRequest {
+				t.Errorf("%v: expected 400, got %v", query, w.Code)
+			}
+		}
+
+		if w := sendData("t2", 2, "?mode=append", []interface{}{1.0, 2.0}); w.Code != http.StatusOK {
+			t.Fatalf("expected the data to be appended, got %v", w.Code)
+		}
+		sendData("t2", 2, "?mode=append", 3.0)
+		doc, _ = jsonStore.FindByTaskId("t2", "perf")
+		if doc == nil || !reflect.DeepEqual(doc.Data, []interface{}{1.0, 2.0, 3.0}) {
+			t.Errorf("expected the elements to be appended, got %+v", doc)
+		}
+	})
+}
+
+// notUpdatableStore is a store whose data can't be merged into or appended to.
+type notUpdatableStore struct {
+	JSONStore
+}
+
+func (s notUpdatableStore) Merge(doc *TaskJSON) error {
+	return errNotUpdatable{doc.Name}
+}
+
+func TestInsertTaskNotUpdatable(t *testing.T) {
+	withMemoryStore(t, nil, func() {
+		SetStore(notUpdatableStore{jsonStore})
+		w := sendData("t1", 1, "?mode=merge", map[string]interface{}{"a": 1.0})
+		if w.Code != http.StatusConflict || w.Body.String() != (errNotUpdatable{"perf"}).Error()+"\n" {
+			t.Errorf("expected a 409 saying why, got %v: %v", w.Code, w.Body.String())
+		}
+	})
+}
@@ -0,0 +1,115 @@
+package evgjson
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// openMetricsContentType is the media type of the OpenMetrics text format.
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	// metricName is the name of the metric family holding every exposed value.
+	metricName = "evg_json_value"
+)
+
+// MetricPaths names the numeric paths into the documents stored under a
+// name whose latest mainline values are exposed as metrics.
+type MetricPaths struct {
+	Name  string   `mapstructure:"name" json:"name"`
+	Paths []string `mapstructure:"paths" json:"paths"`
+}
+
+// metricSample is one exposed value.
+type metricSample struct {
+	variant  string
+	taskName string
+	name     string
+	path     string
+	value    float64
+}
+
+// projectMetrics returns the latest mainline value at each configured path
+// for every variant and task in a project, sorted by their labels.
+func projectMetrics(projectId string, metrics []MetricPaths) ([]metricSample, error) {
+	samples := []metricSample{}
+	for _, metric := range metrics {
+		// only read the parts of each document that lead to the paths
+		latest, err := jsonStore.FindLatest(projectId, metric.Name, normalizeFields(metric.Paths)...)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range latest {
+			for _, path := range metric.Paths {
+				value, ok := lookupFloat(doc.Data, path)
+				if !ok {
+					continue
+				}
+				samples = append(samples, metricSample{
+					variant:  doc.Variant,
+					taskName: doc.TaskName,
+					name:     doc.Name,
+					path:     path,
+					value:    value,
+				})
+			}
+		}
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		a, b := samples[i], samples[j]
+		if a.name != b.name {
+			return a.name < b.name
+		}
+		if a.variant != b.variant {
+			return a.variant < b.variant
+		}
+		if a.taskName != b.taskName {
+			return a.taskName < b.taskName
+		}
+		return a.path < b.path
+	})
+	return samples, nil
+}
+
+// labelEscaper escapes a label value for the OpenMetrics text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeMetrics writes samples in the OpenMetrics text format.
+func writeMetrics(w io.Writer, projectId string, samples []metricSample) error {
+	_, err := fmt.Fprintf(w, "# HELP %v Latest mainline value at a path into a task's json data.\n# TYPE %v gauge\n",
+		metricName, metricName)
+	if err != nil {
+		return err
+	}
+	for _, sample := range samples {
+		_, err = fmt.Fprintf(w, "%v{project=\"%v\",variant=\"%v\",task=\"%v\",name=\"%v\",path=\"%v\"} %v\n",
+			metricName, labelEscaper.Replace(projectId), labelEscaper.Replace(sample.variant),
+			labelEscaper.Replace(sample.taskName), labelEscaper.Replace(sample.name),
+			labelEscaper.Replace(sample.path), strconv.FormatFloat(sample.value, 'g', -1, 64))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(w, "# EOF\n")
+	return err
+}
+
+// getMetrics sends back, in the OpenMetrics text format, the latest
+// mainline value at each of a project's configured metric paths for every
+// variant and task that stored a document under the path's name.
+func getMetrics(w http.ResponseWriter, r *http.Request) {
+	projectId := mux.Vars(r)["project_id"]
+	samples, err := projectMetrics(projectId, settings.Projects[projectId].Metrics)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", openMetricsContentType)
+	w.WriteHeader(http.StatusOK)
+	writeMetrics(w, projectId, samples)
+}
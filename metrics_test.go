@@ -0,0 +1,62 @@
+package evgjson
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	samples := []metricSample{
+		{variant: "linux", taskName: "bench", name: "perf", path: "ops", value: 1500},
+		{variant: `quote"d`, taskName: `back\slash`, name: "perf", path: "results.0.ops", value: 0.25},
+	}
+	buf := &bytes.Buffer{}
+	if err := writeMetrics(buf, "proj\nect", samples); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP evg_json_value Latest mainline value at a path into a task's json data.
+# TYPE evg_json_value gauge
+evg_json_value{project="proj\nect",variant="linux",task="bench",name="perf",path="ops"} 1500
+evg_json_value{project="proj\nect",variant="quote\"d",task="back\\slash",name="perf",path="results.0.ops"} 0.25
+# EOF
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%v\ngot:\n%v", expected, buf.String())
+	}
+}
+
+func TestGetMetricsRoute(t *testing.T) {
+	patch := seriesDoc("patch", 3, map[string]interface{}{"ops": 999.0})
+	patch.IsPatch = true
+	docs := append(versionDocs(), seriesDoc("t2", 2, map[string]interface{}{"ops": 120.0,
+		"results": []interface{}{map[string]interface{}{"ops": 2.0}}}), patch)
+
+	withMemoryStore(t, docs, func() {
+		settings = Settings{Projects: map[string]ProjectSettings{
+			"p": {Metrics: []MetricPaths{{Name: "perf", Paths: []string{"ops", "$.results[0].ops", "label"}}}},
+		}}
+		w := serveGet("/metrics/p", "")
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != openMetricsContentType {
+			t.Fatalf("expected metrics, got %v: %v", w.Code, w.Header())
+		}
+		// only the latest mainline documents are used, and values that
+		// aren't numbers are left out
+		expected := []string{
+			`evg_json_value{project="p",variant="linux",task="bench",name="perf",path="$.results[0].ops"} 2`,
+			`evg_json_value{project="p",variant="linux",task="bench",name="perf",path="ops"} 120`,
+			`evg_json_value{project="p",variant="windows",task="bench",name="perf",path="ops"} 80`,
+		}
+		lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		if len(lines) != len(expected)+3 || !reflect.DeepEqual(lines[2:len(lines)-1], expected) {
+			t.Errorf("expected samples %v, got:\n%v", expected, w.Body.String())
+		}
+
+		w = serveGet("/metrics/q", "")
+		if w.Code != http.StatusOK || strings.Count(w.Body.String(), "\n") != 3 {
+			t.Errorf("expected no samples for a project without metrics, got %v: %v", w.Code, w.Body.String())
+		}
+	})
+}
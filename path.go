@@ -0,0 +1,119 @@
+package evgjson
+
+import (
+	"strconv"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// splitPath breaks a path into a document into its segments. Paths are
+// dot-separated, e.g. "results.0.ops_per_sec", and may also be written
+// in the JSONPath style "$.results[0].ops_per_sec".
+func splitPath(path string) []string {
+	path = strings.TrimPrefix(path, "$")
+	path = strings.Replace(path, "[", ".", -1)
+	path = strings.Replace(path, "]", "", -1)
+	segments := []string{}
+	for _, segment := range strings.Split(path, ".") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// lookupPath returns the value at a path into a json document. Numeric
+// segments index into arrays.
+func lookupPath(data interface{}, path string) (interface{}, bool) {
+	current := data
+	for _, segment := range splitPath(path) {
+		if m, ok := asMap(current); ok {
+			next, ok := m[segment]
+			if !ok {
+				return nil, false
+			}
+			current = next
+			continue
+		}
+		a, ok := current.([]interface{})
+		if !ok {
+			return nil, false
+		}
+		i, err := strconv.Atoi(segment)
+		if err != nil || i < 0 || i >= len(a) {
+			return nil, false
+		}
+		current = a[i]
+	}
+	return current, true
+}
+
+// asMap returns a json object decoded either from json or from bson as a map.
+func asMap(value interface{}) (map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, true
+	case bson.M:
+		return v, true
+	}
+	return nil, false
+}
+
+// joinPath appends a segment to a dot-path.
+func joinPath(path, segment string) string {
+	if path == "" {
+		return segment
+	}
+	return path + "." + segment
+}
+
+// flatten returns every leaf value in a json document keyed by its
+// dot-path. Empty objects and arrays are not leaves and are left out.
+func flatten(data interface{}) map[string]interface{} {
+	leaves := map[string]interface{}{}
+	flattenInto("", data, leaves)
+	return leaves
+}
+
+func flattenInto(path string, value interface{}, leaves map[string]interface{}) {
+	if m, ok := asMap(value); ok {
+		for k, v := range m {
+			flattenInto(joinPath(path, k), v, leaves)
+		}
+		return
+	}
+	if a, ok := value.([]interface{}); ok {
+		for i, v := range a {
+			flattenInto(joinPath(path, strconv.Itoa(i)), v, leaves)
+		}
+		return
+	}
+	leaves[path] = value
+}
+
+// toFloat converts a numeric json or bson value to a float64.
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// lookupFloat returns the number at a path into a json document.
+func lookupFloat(data interface{}, path string) (float64, bool) {
+	value, ok := lookupPath(data, path)
+	if !ok {
+		return 0, false
+	}
+	return toFloat(value)
+}
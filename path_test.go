@@ -0,0 +1,73 @@
+package evgjson
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitPath(t *testing.T) {
+	cases := map[string][]string{
+		"":                 {},
+		"$":                {},
+		"a.b":              {"a", "b"},
+		"results.0.ops":    {"results", "0", "ops"},
+		"$.results[0].ops": {"results", "0", "ops"},
+		"$[1][2]":          {"1", "2"},
+		"..a..b.":          {"a", "b"},
+	}
+	for path, expected := range cases {
+		if segments := splitPath(path); !reflect.DeepEqual(segments, expected) {
+			t.Errorf("'%v': expected %v, got %v", path, expected, segments)
+		}
+	}
+}
+
+func TestLookupPath(t *testing.T) {
+	data := map[string]interface{}{
+		"results": []interface{}{map[string]interface{}{"ops": 5.0}},
+		"counts":  map[string]interface{}{"0": 2.0},
+		"label":   "x",
+	}
+	for _, path := range []string{"results.0.ops", "$.results[0].ops"} {
+		if value, ok := lookupFloat(data, path); !ok || value != 5 {
+			t.Errorf("%v: expected 5, got %v", path, value)
+		}
+	}
+	// a numeric segment names a field of an object
+	if value, ok := lookupFloat(data, "counts.0"); !ok || value != 2 {
+		t.Errorf("expected 2, got %v", value)
+	}
+	for _, path := range []string{"results.1.ops", "results.-1.ops", "results.x", "results.ops", "results.0.ops.y",
+		"missing"} {
+		if _, ok := lookupPath(data, path); ok {
+			t.Errorf("%v: expected no value", path)
+		}
+	}
+	if _, ok := lookupFloat(data, "label"); ok {
+		t.Errorf("expected a string not to be a number")
+	}
+	if value, ok := lookupPath(data, ""); !ok || !reflect.DeepEqual(value, data) {
+		t.Errorf("expected the empty path to be the whole document, got %v", value)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	data := map[string]interface{}{
+		"a":     map[string]interface{}{"b": 1.0, "c": []interface{}{"x", map[string]interface{}{"d": true}}},
+		"e":     nil,
+		"empty": map[string]interface{}{},
+		"none":  []interface{}{},
+	}
+	expected := map[string]interface{}{
+		"a.b":     1.0,
+		"a.c.0":   "x",
+		"a.c.1.d": true,
+		"e":       nil,
+	}
+	if leaves := flatten(data); !reflect.DeepEqual(leaves, expected) {
+		t.Errorf("expected %v, got %v", expected, leaves)
+	}
+	if leaves := flatten(3.0); !reflect.DeepEqual(leaves, map[string]interface{}{"": 3.0}) {
+		t.Errorf("expected a scalar at the empty path, got %v", leaves)
+	}
+}
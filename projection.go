@@ -0,0 +1,143 @@
+package evgjson
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// fieldsParam reads the paths into a document's data that a read route
+// should return, from the "fields" or "path" query parameters. Either may
+// be repeated or hold a comma-separated list. It returns nil, meaning the
+// whole document, if neither is set. Array indexes in the paths are not
+// applied: the whole array is returned.
+func fieldsParam(r *http.Request) []string {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+	fields := []string{}
+	for _, param := range []string{"fields", "path"} {
+		for _, value := range r.Form[param] {
+			for _, field := range strings.Split(value, ",") {
+				if field = strings.TrimSpace(field); field != "" {
+					fields = append(fields, field)
+				}
+			}
+		}
+	}
+	return normalizeFields(fields)
+}
+
+// normalizeFields converts paths to dot-paths and drops any path that is
+// already covered by another, since the database rejects projections
+// that overlap. A projection cannot index into an array, so each path is
+// cut off before its first numeric segment and the whole array is kept;
+// if that leaves nothing of a path, the whole document is returned.
+func normalizeFields(fields []string) []string {
+	paths := []string{}
+	for _, field := range fields {
+		segments := splitPath(field)
+		if len(segments) == 0 {
+			continue
+		}
+		prefix := []string{}
+		for _, segment := range segments {
+			if _, err := strconv.Atoi(segment); err == nil {
+				break
+			}
+			prefix = append(prefix, segment)
+		}
+		if len(prefix) == 0 {
+			return nil
+		}
+		paths = append(paths, strings.Join(prefix, "."))
+	}
+	normalized := []string{}
+	for i, path := range paths {
+		covered := false
+		for j, other := range paths {
+			if i == j {
+				continue
+			}
+			// drop descendants of other paths, and all but the first of duplicates
+			if strings.HasPrefix(path, other+".") || (path == other && j < i) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			normalized = append(normalized, path)
+		}
+	}
+	if len(normalized) == 0 {
+		return nil
+	}
+	return normalized
+}
+
+// projectionKeys returns the top-level keys of a TaskJSON along with the
+// given paths into its data, for use as a database projection.
+func projectionKeys(fields []string) []string {
+	keys := append([]string{}, metadataKeys...)
+	for _, field := range fields {
+		keys = append(keys, DataKey+"."+field)
+	}
+	return keys
+}
+
+// projectData keeps only the given paths into a document, the way the
+// database applies a projection: a path through an array is applied to
+// each object in the array, and other array elements are dropped.
+func projectData(data interface{}, fields []string) interface{} {
+	if len(fields) == 0 {
+		return data
+	}
+	paths := make([][]string, 0, len(fields))
+	for _, field := range fields {
+		paths = append(paths, strings.Split(field, "."))
+	}
+	projected, _ := projectValue(data, paths)
+	return projected
+}
+
+// projectValue returns the parts of value selected by paths, and whether
+// any part of it was selected.
+func projectValue(value interface{}, paths [][]string) (interface{}, bool) {
+	if a, ok := value.([]interface{}); ok {
+		out := []interface{}{}
+		for _, elem := range a {
+			if _, isMap := asMap(elem); !isMap {
+				continue
+			}
+			projected, _ := projectValue(elem, paths)
+			out = append(out, projected)
+		}
+		return out, true
+	}
+
+	m, ok := asMap(value)
+	if !ok {
+		return nil, false
+	}
+	// group the remainder of each path by its first segment
+	rest := map[string][][]string{}
+	whole := map[string]bool{}
+	for _, path := range paths {
+		if len(path) == 1 {
+			whole[path[0]] = true
+		} else {
+			rest[path[0]] = append(rest[path[0]], path[1:])
+		}
+	}
+	out := map[string]interface{}{}
+	for k, v := range m {
+		if whole[k] {
+			out[k] = v
+		} else if subpaths, ok := rest[k]; ok {
+			if projected, ok := projectValue(v, subpaths); ok {
+				out[k] = projected
+			}
+		}
+	}
+	return out, true
+}
@@ -0,0 +1,57 @@
+package evgjson
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeFields(t *testing.T) {
+	cases := []struct {
+		fields   []string
+		expected []string
+	}{
+		{nil, nil},
+		{[]string{"", "$"}, nil},
+		{[]string{"a.b", "$.c.d"}, []string{"a.b", "c.d"}},
+		// covered and duplicate paths are dropped
+		{[]string{"a.b", "a", "c", "c"}, []string{"a", "c"}},
+		// paths are cut off before array indexes
+		{[]string{"results.0.ops", "$.other[1]"}, []string{"results", "other"}},
+		// a path into a top-level array needs the whole document
+		{[]string{"a", "[0].b"}, nil},
+	}
+	for _, c := range cases {
+		if normalized := normalizeFields(c.fields); !reflect.DeepEqual(normalized, c.expected) {
+			t.Errorf("%v: expected %v, got %v", c.fields, c.expected, normalized)
+		}
+	}
+}
+
+func TestProjectData(t *testing.T) {
+	data := map[string]interface{}{
+		"a": map[string]interface{}{"b": 1.0, "c": 2.0},
+		"list": []interface{}{
+			map[string]interface{}{"x": 1.0, "y": 2.0},
+			map[string]interface{}{"y": 3.0},
+			4.0,
+		},
+		"d": 5.0,
+	}
+	cases := []struct {
+		fields   []string
+		expected interface{}
+	}{
+		{nil, data},
+		{[]string{"a.b"}, map[string]interface{}{"a": map[string]interface{}{"b": 1.0}}},
+		{[]string{"d", "a.c"}, map[string]interface{}{"d": 5.0, "a": map[string]interface{}{"c": 2.0}}},
+		// a path through an array applies to each object in it
+		{[]string{"list.x"}, map[string]interface{}{"list": []interface{}{
+			map[string]interface{}{"x": 1.0}, map[string]interface{}{}}}},
+		{[]string{"missing"}, map[string]interface{}{}},
+	}
+	for _, c := range cases {
+		if projected := projectData(data, c.fields); !reflect.DeepEqual(projected, c.expected) {
+			t.Errorf("%v: expected %v, got %v", c.fields, c.expected, projected)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package evgjson
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/evergreen-ci/evergreen"
+	"github.com/evergreen-ci/evergreen/model/task"
+	"github.com/evergreen-ci/evergreen/plugin"
+	"github.com/evergreen-ci/evergreen/util"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// Directions a metric can improve in. A metric without a direction
+	// regresses when it moves too far either way.
+	HigherIsBetter = "higher_is_better"
+	LowerIsBetter  = "lower_is_better"
+
+	// Methods for computing a baseline from history.
+	MeanMethod   = "mean"
+	MedianMethod = "median"
+
+	defaultRegressionWindow    = 20
+	defaultRegressionThreshold = 2.0
+	minRegressionSamples       = 3
+
+	// madScale makes the median absolute deviation comparable to a
+	// standard deviation for normally distributed values.
+	madScale = 1.4826
+)
+
+// MetricSpec selects a number in a document and says how to judge changes to it.
+type MetricSpec struct {
+	// Path is a dot-path into the document's data.
+	Path string `mapstructure:"path" json:"path"`
+	// Threshold is how many deviations from the baseline a value may move
+	// before it counts as a regression.
+	Threshold float64 `mapstructure:"threshold" json:"threshold"`
+	// Direction is HigherIsBetter, LowerIsBetter or blank.
+	Direction string `mapstructure:"direction" json:"direction"`
+}
+
+// RegressionRequest describes which metrics to check and how to build
+// their baselines.
+type RegressionRequest struct {
+	Metrics []MetricSpec `json:"metrics"`
+	// Method is MeanMethod, which compares against the mean and standard
+	// deviation of the history, or MedianMethod, which uses the median and
+	// the median absolute deviation.
+	Method string `json:"method"`
+	// Window is the number of prior documents the baseline is built from.
+	Window int `json:"window"`
+}
+
+// MetricVerdict is the result of checking one metric.
+type MetricVerdict struct {
+	MetricSpec
+	Value     float64 `json:"value"`
+	Baseline  float64 `json:"baseline"`
+	Deviation float64 `json:"deviation"`
+	// Score is the signed number of deviations Value is from Baseline.
+	Score     float64 `json:"score"`
+	Samples   int     `json:"samples"`
+	Regressed bool    `json:"regressed"`
+	// Message explains why a metric could not be checked.
+	Message string `json:"message,omitempty"`
+}
+
+// RegressionReport is the verdict for all the metrics checked for a task.
+type RegressionReport struct {
+	TaskId    string          `json:"task_id"`
+	Name      string          `json:"name"`
+	Method    string          `json:"method"`
+	Window    int             `json:"window"`
+	Regressed bool            `json:"regressed"`
+	Metrics   []MetricVerdict `json:"metrics"`
+}
+
+// parseRegressionRequest reads a RegressionRequest from a POST body, or
+// from the "path", "threshold", "direction", "method" and "window" query
+// parameters otherwise.
+func parseRegressionRequest(r *http.Request) (*RegressionRequest, error) {
+	req := &RegressionRequest{}
+	if r.Method == "POST" {
+		if err := util.ReadJSONInto(r.Body, req); err != nil {
+			return nil, err
+		}
+	} else {
+		threshold := 0.0
+		if t := r.FormValue("threshold"); t != "" {
+			var err error
+			if threshold, err = strconv.ParseFloat(t, 64); err != nil {
+				return nil, fmt.Errorf("invalid threshold '%v'", t)
+			}
+		}
+		for _, path := range r.URL.Query()["path"] {
+			req.Metrics = append(req.Metrics, MetricSpec{
+				Path:      path,
+				Threshold: threshold,
+				Direction: r.FormValue("direction"),
+			})
+		}
+		req.Method = r.FormValue("method")
+		if w := r.FormValue("window"); w != "" {
+			window, err := strconv.Atoi(w)
+			if err != nil {
+				return nil, fmt.Errorf("invalid window '%v'", w)
+			}
+			req.Window = window
+		}
+	}
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// validate checks the request and fills in its defaults.
+func (req *RegressionRequest) validate() error {
+	if len(req.Metrics) == 0 {
+		return fmt.Errorf("at least one metric path must be given")
+	}
+	if req.Method == "" {
+		req.Method = MeanMethod
+	}
+	if req.Method != MeanMethod && req.Method != MedianMethod {
+		return fmt.Errorf("unknown method '%v'", req.Method)
+	}
+	if req.Window <= 0 {
+		req.Window = defaultRegressionWindow
+	}
+	for i := range req.Metrics {
+		m := &req.Metrics[i]
+		if m.Path == "" {
+			return fmt.Errorf("metric path must not be blank")
+		}
+		if m.Threshold <= 0 {
+			m.Threshold = defaultRegressionThreshold
+		}
+		if m.Direction != "" && m.Direction != HigherIsBetter && m.Direction != LowerIsBetter {
+			return fmt.Errorf("unknown direction '%v' for '%v'", m.Direction, m.Path)
+		}
+	}
+	return nil
+}
+
+// findRegressions checks a task's document against the non-patch history
+// that ends at revision order number lastOrder. It returns nil if the task
+// has no document stored under name.
+func findRegressions(t *task.Task, name string, lastOrder int, req *RegressionRequest) (*RegressionReport, error) {
+	current, err := jsonStore.FindByTaskId(t.Id, name)
+	if err != nil || current == nil {
+		return nil, err
+	}
+	history, err := jsonStore.FindHistory(HistoryQuery{
+		SeriesKey: SeriesKey{
+			ProjectId: t.Project,
+			Variant:   t.BuildVariant,
+			TaskName:  t.DisplayName,
+			Name:      name,
+		},
+		Order:  lastOrder,
+		Before: req.Window,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RegressionReport{
+		TaskId: t.Id,
+		Name:   name,
+		Method: req.Method,
+		Window: req.Window,
+	}
+	for _, metric := range req.Metrics {
+		samples := []float64{}
+		for _, doc := range history {
+			if v, ok := lookupFloat(doc.Data, metric.Path); ok {
+				samples = append(samples, v)
+			}
+		}
+		verdict := judgeMetric(metric, req.Method, current.Data, samples)
+		report.Regressed = report.Regressed || verdict.Regressed
+		report.Metrics = append(report.Metrics, verdict)
+	}
+	return report, nil
+}
+
+// judgeMetric compares a metric in data against the baseline built from samples.
+func judgeMetric(metric MetricSpec, method string, data interface{}, samples []float64) MetricVerdict {
+	verdict := MetricVerdict{MetricSpec: metric, Samples: len(samples)}
+	value, ok := lookupFloat(data, metric.Path)
+	if !ok {
+		verdict.Message = "no numeric value at path"
+		return verdict
+	}
+	verdict.Value = value
+	if len(samples) < minRegressionSamples {
+		verdict.Message = fmt.Sprintf("need at least %v historical values, found %v",
+			minRegressionSamples, len(samples))
+		return verdict
+	}
+
+	if method == MedianMethod {
+		verdict.Baseline = median(samples)
+		verdict.Deviation = madScale * medianAbsoluteDeviation(samples, verdict.Baseline)
+	} else {
+		verdict.Baseline = mean(samples)
+		verdict.Deviation = stdDev(samples, verdict.Baseline)
+	}
+
+	change := value - verdict.Baseline
+	worse := change != 0
+	if metric.Direction == HigherIsBetter {
+		worse = change < 0
+	} else if metric.Direction == LowerIsBetter {
+		worse = change > 0
+	}
+	if verdict.Deviation == 0 {
+		// a perfectly stable history makes any change in the wrong direction a regression
+		verdict.Regressed = worse
+		return verdict
+	}
+	verdict.Score = change / verdict.Deviation
+	verdict.Regressed = worse && math.Abs(verdict.Score) > metric.Threshold
+	return verdict
+}
+
+func mean(values []float64) float64 {
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	return total / float64(len(values))
+}
+
+func stdDev(values []float64, center float64) float64 {
+	total := 0.0
+	for _, v := range values {
+		total += (v - center) * (v - center)
+	}
+	return math.Sqrt(total / float64(len(values)))
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+func medianAbsoluteDeviation(values []float64, center float64) float64 {
+	deviations := make([]float64, 0, len(values))
+	for _, v := range values {
+		deviations = append(deviations, math.Abs(v-center))
+	}
+	return median(deviations)
+}
+
+func getRegressions(t *task.Task, w http.ResponseWriter, r *http.Request) {
+	req, err := parseRegressionRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// the history ends just before the task, or at the base commit of a
+	// patch, whose order number is not a mainline one
+	lastOrder := t.RevisionOrderNumber - 1
+	if t.Requester == evergreen.PatchVersionRequester {
+		base, err := t.FindTaskOnBaseCommit()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if base == nil {
+			http.Error(w, "no task found on the base commit", http.StatusNotFound)
+			return
+		}
+		lastOrder = base.RevisionOrderNumber
+	}
+	report, err := findRegressions(t, mux.Vars(r)["name"], lastOrder, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if report == nil {
+		http.Error(w, "{}", http.StatusNotFound)
+		return
+	}
+	plugin.WriteJSON(w, http.StatusOK, report)
+}
+
+// apiGetRegressions checks the requesting task's document for regressions.
+func apiGetRegressions(w http.ResponseWriter, r *http.Request) {
+	t := plugin.GetTask(r)
+	if t == nil {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+	getRegressions(t, w, r)
+}
+
+// uiGetRegressions checks a task's document for regressions.
+func uiGetRegressions(w http.ResponseWriter, r *http.Request) {
+	t, err := task.FindOne(task.ById(mux.Vars(r)["task_id"]))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if t == nil {
+		http.Error(w, "{}", http.StatusNotFound)
+		return
+	}
+	getRegressions(t, w, r)
+}
@@ -0,0 +1,114 @@
+package evgjson
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+)
+
+func TestMedian(t *testing.T) {
+	cases := []struct {
+		values   []float64
+		expected float64
+	}{
+		{[]float64{3, 1, 2}, 2},
+		{[]float64{4, 1, 3, 2}, 2.5},
+		{[]float64{7}, 7},
+	}
+	for _, c := range cases {
+		if m := median(c.values); m != c.expected {
+			t.Errorf("median of %v: expected %v, got %v", c.values, c.expected, m)
+		}
+	}
+	values := []float64{3, 1, 2}
+	median(values)
+	if values[0] != 3 {
+		t.Errorf("median sorted its input: %v", values)
+	}
+}
+
+func TestMedianAbsoluteDeviation(t *testing.T) {
+	values := []float64{1, 1, 2, 2, 4, 6, 9}
+	if mad := medianAbsoluteDeviation(values, median(values)); mad != 1 {
+		t.Errorf("expected 1, got %v", mad)
+	}
+}
+
+func TestJudgeMetric(t *testing.T) {
+	stable := []float64{100, 100, 100, 100}
+	noisy := []float64{90, 110, 90, 110}
+	data := map[string]interface{}{"ops": 80.0, "label": "x"}
+	cases := []struct {
+		name      string
+		metric    MetricSpec
+		method    string
+		samples   []float64
+		regressed bool
+		message   bool
+	}{
+		{"drop beyond threshold", MetricSpec{Path: "ops", Threshold: 1, Direction: HigherIsBetter}, MeanMethod, noisy, true, false},
+		{"drop within threshold", MetricSpec{Path: "ops", Threshold: 3, Direction: HigherIsBetter}, MeanMethod, noisy, false, false},
+		{"drop where lower is better", MetricSpec{Path: "ops", Threshold: 1, Direction: LowerIsBetter}, MeanMethod, noisy, false, false},
+		{"any change from stable history", MetricSpec{Path: "ops", Threshold: 100}, MedianMethod, stable, true, false},
+		{"too few samples", MetricSpec{Path: "ops", Threshold: 1}, MeanMethod, stable[:2], false, true},
+		{"missing path", MetricSpec{Path: "missing", Threshold: 1}, MeanMethod, stable, false, true},
+		{"value that is not a number", MetricSpec{Path: "label", Threshold: 1}, MeanMethod, stable, false, true},
+	}
+	for _, c := range cases {
+		verdict := judgeMetric(c.metric, c.method, data, c.samples)
+		if verdict.Regressed != c.regressed {
+			t.Errorf("%v: expected regressed to be %v, got %+v", c.name, c.regressed, verdict)
+		}
+		if (verdict.Message != "") != c.message {
+			t.Errorf("%v: unexpected message in %+v", c.name, verdict)
+		}
+	}
+
+	verdict := judgeMetric(MetricSpec{Path: "ops", Threshold: 1}, MeanMethod, data, noisy)
+	if verdict.Baseline != 100 || verdict.Deviation != 10 || math.Abs(verdict.Score+2) > 1e-9 {
+		t.Errorf("expected a baseline of 100, deviation of 10 and score of -2, got %+v", verdict)
+	}
+}
+
+func TestGetRegressions(t *testing.T) {
+	docs := []TaskJSON{}
+	for order, ops := range []float64{90, 110, 90, 110} {
+		docs = append(docs, seriesDoc(fmt.Sprintf("t%v", order+1), order+1, map[string]interface{}{"ops": ops}))
+	}
+	docs = append(docs, seriesDoc("t5", 5, map[string]interface{}{"ops": 70.0}))
+
+	withMemoryStore(t, docs, func() {
+		handler := forTask(seriesTask("t5", 5), getRegressions)
+		w := serveRoute("/regression/{name}", handler,
+			newRequest("GET", "/regression/perf?path=ops&direction=higher_is_better", nil))
+		report := RegressionReport{}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &report) != nil {
+			t.Fatalf("expected a report, got %v: %v", w.Code, w.Body.String())
+		}
+		if !report.Regressed || len(report.Metrics) != 1 || report.Metrics[0].Samples != 4 {
+			t.Errorf("expected a regression against four samples, got %+v", report)
+		}
+
+		// the window only reaches back two documents
+		request := &RegressionRequest{Metrics: []MetricSpec{{Path: "ops"}}, Window: 2}
+		w = serveRoute("/regression/{name}", handler, newRequest("POST", "/regression/perf", request))
+		report = RegressionReport{}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &report) != nil {
+			t.Fatalf("expected a report, got %v: %v", w.Code, w.Body.String())
+		}
+		if report.Regressed || report.Metrics[0].Message == "" {
+			t.Errorf("expected too few samples to check, got %+v", report)
+		}
+
+		w = serveRoute("/regression/{name}", handler, newRequest("GET", "/regression/perf?method=mode&path=ops", nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("unknown method: expected 400, got %v", w.Code)
+		}
+		w = serveRoute("/regression/{name}", handler, newRequest("GET", "/regression/other?path=ops", nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("missing document: expected 404, got %v", w.Code)
+		}
+	})
+}
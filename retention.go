@@ -0,0 +1,157 @@
+package evgjson
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/10gen-labs/slogger/v1"
+	"github.com/evergreen-ci/evergreen"
+	"github.com/evergreen-ci/evergreen/plugin"
+	"github.com/gorilla/mux"
+)
+
+const defaultPruneIntervalMinutes = 60
+
+// RetentionPolicy says how long a project keeps its documents. Tagged
+// documents and baselines are kept forever.
+type RetentionPolicy struct {
+	// PatchDays is how many days patch documents are kept, or 0 to keep them forever.
+	PatchDays int `mapstructure:"patch_days" json:"patch_days"`
+	// MainlineDays is how many days untagged mainline documents are kept,
+	// or 0 to keep them forever.
+	MainlineDays int `mapstructure:"mainline_days" json:"mainline_days"`
+}
+
+// PruneQuery selects the untagged documents of a project created before a
+// time that are not baselines.
+type PruneQuery struct {
+	ProjectId     string
+	IsPatch       bool
+	CreatedBefore time.Time
+	// Baselines are the project's baselines, whose documents are kept.
+	Baselines []Baseline
+}
+
+// PruneReport lists the documents a project's retention policy removes.
+type PruneReport struct {
+	ProjectId string          `json:"project_id"`
+	Policy    RetentionPolicy `json:"policy"`
+	DryRun    bool            `json:"dry_run"`
+	Patches   int             `json:"patches"`
+	Mainline  int             `json:"mainline"`
+	// Documents holds the metadata of each document removed, oldest first.
+	// It is only filled in for a dry run.
+	Documents []TaskJSON `json:"documents,omitempty"`
+}
+
+// pruneQueries returns the queries for the documents a policy lets go at a time.
+func pruneQueries(projectId string, policy RetentionPolicy, now time.Time) ([]PruneQuery, error) {
+	if policy.PatchDays <= 0 && policy.MainlineDays <= 0 {
+		return nil, nil
+	}
+	baselines, err := jsonStore.FindBaselines(projectId)
+	if err != nil {
+		return nil, err
+	}
+	queries := []PruneQuery{}
+	if policy.PatchDays > 0 {
+		queries = append(queries, PruneQuery{
+			ProjectId:     projectId,
+			IsPatch:       true,
+			CreatedBefore: now.AddDate(0, 0, -policy.PatchDays),
+			Baselines:     baselines,
+		})
+	}
+	if policy.MainlineDays > 0 {
+		queries = append(queries, PruneQuery{
+			ProjectId:     projectId,
+			CreatedBefore: now.AddDate(0, 0, -policy.MainlineDays),
+			Baselines:     baselines,
+		})
+	}
+	return queries, nil
+}
+
+// pruneProject removes the documents a project's retention policy lets go,
+// or only reports them if dryRun is set.
+func pruneProject(projectId string, policy RetentionPolicy, now time.Time, dryRun bool) (*PruneReport, error) {
+	report := &PruneReport{ProjectId: projectId, Policy: policy, DryRun: dryRun}
+	queries, err := pruneQueries(projectId, policy, now)
+	if err != nil {
+		return nil, err
+	}
+	for _, q := range queries {
+		count := 0
+		if dryRun {
+			docs, err := jsonStore.FindPrunable(q)
+			if err != nil {
+				return nil, err
+			}
+			count = len(docs)
+			report.Documents = append(report.Documents, docs...)
+		} else if count, err = jsonStore.RemovePrunable(q); err != nil {
+			return nil, err
+		}
+		if q.IsPatch {
+			report.Patches = count
+		} else {
+			report.Mainline = count
+		}
+	}
+	sort.SliceStable(report.Documents, func(i, j int) bool {
+		return report.Documents[i].CreateTime.Before(report.Documents[j].CreateTime)
+	})
+	return report, nil
+}
+
+// pruneAll applies the retention policy of every configured project.
+func pruneAll() {
+	for projectId, project := range settings.Projects {
+		report, err := pruneProject(projectId, project.Retention, time.Now(), false)
+		if err != nil {
+			evergreen.Logger.Logf(slogger.ERROR, "Pruning json data for project '%v' failed: %v", projectId, err)
+			continue
+		}
+		if report.Patches > 0 || report.Mainline > 0 {
+			evergreen.Logger.Logf(slogger.INFO, "Pruned %v patch and %v mainline json documents for project '%v'",
+				report.Patches, report.Mainline, projectId)
+		}
+	}
+}
+
+var startPruningOnce sync.Once
+
+// startPruning applies the retention policies in the background, once when
+// it is called and then at the configured interval.
+func startPruning() {
+	startPruningOnce.Do(func() {
+		interval := time.Duration(settings.PruneIntervalMinutes) * time.Minute
+		if interval <= 0 {
+			interval = defaultPruneIntervalMinutes * time.Minute
+		}
+		go func() {
+			pruneAll()
+			for range time.Tick(interval) {
+				pruneAll()
+			}
+		}()
+	})
+}
+
+// getRetentionReport sends back what the project's retention policy
+// would remove if it were applied now, without removing anything. Only
+// admins may ask for it.
+func getRetentionReport(w http.ResponseWriter, r *http.Request) {
+	projectId := mux.Vars(r)["project_id"]
+	if !checkAdmin(w, r, projectId, "see a retention report") {
+		return
+	}
+	report, err := pruneProject(projectId, settings.Projects[projectId].Retention, time.Now(), true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	plugin.WriteJSON(w, http.StatusOK, report)
+}
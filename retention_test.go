@@ -0,0 +1,109 @@
+package evgjson
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// retentionDocs returns mainline documents at orders 1 to 4, with the
+// first tagged, and a patch document as old as the second.
+func retentionDocs() []TaskJSON {
+	docs := []TaskJSON{}
+	for _, taskId := range []string{"t1", "t2", "t3", "t4"} {
+		docs = append(docs, seriesDoc(taskId, len(docs)+1, map[string]interface{}{"ops": 1.0}))
+	}
+	docs[0].Tags = []TagInfo{{Name: "release"}}
+	patch := seriesDoc("patch", 5, nil)
+	patch.IsPatch = true
+	patch.CreateTime = docs[1].CreateTime
+	return append(docs, patch)
+}
+
+// taskIds returns the task ids of docs.
+func taskIds(docs []TaskJSON) []string {
+	ids := []string{}
+	for _, doc := range docs {
+		ids = append(ids, doc.TaskId)
+	}
+	return ids
+}
+
+func TestPruneProject(t *testing.T) {
+	withMemoryStore(t, retentionDocs(), func() {
+		key := SeriesKey{ProjectId: "p", Variant: "linux", TaskName: "bench", Name: "perf"}
+		if err := jsonStore.SetBaseline(&Baseline{ProjectId: "p", Variant: "linux", TaskName: "bench",
+			Name: "perf", TaskId: "t2"}); err != nil {
+			t.Fatal(err)
+		}
+		policy := RetentionPolicy{PatchDays: 1, MainlineDays: 2}
+		now := time.Date(2016, 1, 6, 0, 0, 0, 0, time.UTC)
+
+		report, err := pruneProject("p", policy, now, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// t4 is too new, t1 is tagged and t2 is the baseline
+		if !report.DryRun || report.Patches != 1 || report.Mainline != 1 ||
+			!reflect.DeepEqual(taskIds(report.Documents), []string{"patch", "t3"}) {
+			t.Errorf("expected t3 and the patch to be reported, got %+v", report)
+		}
+		if report.Documents[0].Data != nil {
+			t.Errorf("expected the report to leave out the data, got %v", report.Documents[0].Data)
+		}
+		history, _ := jsonStore.FindHistory(HistoryQuery{SeriesKey: key, Order: 5, Before: 5})
+		if len(history) != 4 {
+			t.Fatalf("expected a dry run to remove nothing, got %v", orders(history))
+		}
+
+		if report, err = pruneProject("p", policy, now, false); err != nil {
+			t.Fatal(err)
+		}
+		if report.Patches != 1 || report.Mainline != 1 || len(report.Documents) != 0 {
+			t.Errorf("expected two documents to be removed, got %+v", report)
+		}
+		history, _ = jsonStore.FindHistory(HistoryQuery{SeriesKey: key, Order: 5, Before: 5})
+		if !reflect.DeepEqual(taskIds(history), []string{"t1", "t2", "t4"}) {
+			t.Errorf("expected t1, t2 and t4 to be kept, got %v", taskIds(history))
+		}
+		if doc, _ := jsonStore.FindByTaskId("patch", "perf"); doc != nil {
+			t.Errorf("expected the patch to be removed, got %+v", doc)
+		}
+
+		if report, err = pruneProject("p", RetentionPolicy{}, now, false); err != nil || report.Mainline != 0 {
+			t.Errorf("expected no policy to remove nothing, got %+v (%v)", report, err)
+		}
+	})
+}
+
+func TestGetRetentionReport(t *testing.T) {
+	withMemoryStore(t, retentionDocs(), func() {
+		settings = Settings{Projects: map[string]ProjectSettings{"p": {
+			Admins:    []string{"admin"},
+			Retention: RetentionPolicy{MainlineDays: 1},
+		}}}
+		for _, r := range []*http.Request{
+			httptest.NewRequest("GET", "/retention/p", nil),
+			asUser(httptest.NewRequest("GET", "/retention/p", nil), "someone"),
+		} {
+			if w := serveUI(r); w.Code != http.StatusForbidden {
+				t.Errorf("expected 403 for a non-admin, got %v", w.Code)
+			}
+		}
+
+		w := serveUI(asUser(httptest.NewRequest("GET", "/retention/p", nil), "admin"))
+		report := PruneReport{}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &report) != nil {
+			t.Fatalf("expected the report, got %v: %v", w.Code, w.Body.String())
+		}
+		if !report.DryRun || report.Mainline != 3 || report.Patches != 0 {
+			t.Errorf("expected the three untagged mainline documents, got %+v", report)
+		}
+		if doc, _ := jsonStore.FindByTaskId("t2", "perf"); doc == nil {
+			t.Errorf("expected the report to remove nothing")
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package evgjson
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/evergreen-ci/evergreen/db/bsonutil"
+	"github.com/evergreen-ci/evergreen/plugin"
+	"github.com/gorilla/mux"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// Schema is a JSON Schema that every document a project stores under a
+// name must satisfy. The schema is kept as its raw JSON text, since schema
+// keywords such as "$ref" are not valid field names in the database.
+type Schema struct {
+	ProjectId  string    `bson:"project_id" json:"project_id"`
+	Name       string    `bson:"name" json:"name"`
+	Schema     string    `bson:"schema" json:"-"`
+	CreateTime time.Time `bson:"create_time" json:"create_time"`
+}
+
+var (
+	// BSON fields for the Schema struct
+	SchemaProjectIdKey  = bsonutil.MustHaveTag(Schema{}, "ProjectId")
+	SchemaNameKey       = bsonutil.MustHaveTag(Schema{}, "Name")
+	SchemaSchemaKey     = bsonutil.MustHaveTag(Schema{}, "Schema")
+	SchemaCreateTimeKey = bsonutil.MustHaveTag(Schema{}, "CreateTime")
+)
+
+// MarshalJSON sends the schema back as a json object rather than a string.
+func (s Schema) MarshalJSON() ([]byte, error) {
+	type schemaJSON Schema
+	return json.Marshal(struct {
+		schemaJSON
+		Schema json.RawMessage `json:"schema"`
+	}{schemaJSON(s), json.RawMessage(s.Schema)})
+}
+
+// SchemaViolations is sent back when a document does not satisfy its schema.
+type SchemaViolations struct {
+	Message string   `json:"message"`
+	Errors  []string `json:"errors"`
+}
+
+// compileSchema checks that text is a valid JSON Schema.
+func compileSchema(text string) (*gojsonschema.Schema, error) {
+	return gojsonschema.NewSchema(gojsonschema.NewStringLoader(text))
+}
+
+// validateSchema checks data against a JSON Schema, returning a
+// description of each violation.
+func validateSchema(schema *gojsonschema.Schema, data interface{}) ([]string, error) {
+	result, err := schema.Validate(gojsonschema.NewGoLoader(data))
+	if err != nil {
+		return nil, err
+	}
+	violations := []string{}
+	for _, e := range result.Errors() {
+		violations = append(violations, e.String())
+	}
+	return violations, nil
+}
+
+// validateForProject checks data against the schema registered for
+// the project and name, if there is one.
+func validateForProject(projectId, name string, data interface{}) ([]string, error) {
+	registered, err := jsonStore.FindSchema(projectId, name)
+	if err != nil || registered == nil {
+		return nil, err
+	}
+	schema, err := compileSchema(registered.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("schema for '%v' is invalid: %v", name, err)
+	}
+	return validateSchema(schema, data)
+}
+
+// handleSchema gets, registers or removes the schema for a project and
+// name. Only admins may register or remove one.
+func handleSchema(w http.ResponseWriter, r *http.Request) {
+	projectId := mux.Vars(r)["project_id"]
+	name := mux.Vars(r)["name"]
+	if r.Method != "GET" && !checkAdmin(w, r, projectId, "change a schema") {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		schema, err := jsonStore.FindSchema(projectId, name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if schema == nil {
+			http.Error(w, "{}", http.StatusNotFound)
+			return
+		}
+		plugin.WriteJSON(w, http.StatusOK, schema)
+	case "POST", "PUT":
+		text, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if _, err = compileSchema(string(text)); err != nil {
+			http.Error(w, fmt.Sprintf("invalid schema: %v", err), http.StatusBadRequest)
+			return
+		}
+		err = jsonStore.SetSchema(&Schema{
+			ProjectId:  projectId,
+			Name:       name,
+			Schema:     string(text),
+			CreateTime: time.Now(),
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		plugin.WriteJSON(w, http.StatusOK, "")
+	case "DELETE":
+		if err := jsonStore.RemoveSchema(projectId, name); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		plugin.WriteJSON(w, http.StatusOK, "")
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,120 @@
+package evgjson
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/evergreen-ci/evergreen/model/user"
+	"github.com/evergreen-ci/evergreen/plugin"
+)
+
+const testSchema = `{"type": "object", "required": ["ops"], "properties": {"ops": {"type": "number"}}}`
+
+// asUser returns r made by a user.
+func asUser(r *http.Request, username string) *http.Request {
+	plugin.SetUser(&user.DBUser{Id: username}, r)
+	return r
+}
+
+func TestValidateForProject(t *testing.T) {
+	withMemoryStore(t, nil, func() {
+		violations, err := validateForProject("p", "perf", map[string]interface{}{})
+		if err != nil || len(violations) != 0 {
+			t.Errorf("expected no violations without a schema, got %v (%v)", violations, err)
+		}
+
+		if err = jsonStore.SetSchema(&Schema{ProjectId: "p", Name: "perf", Schema: testSchema}); err != nil {
+			t.Fatal(err)
+		}
+		violations, err = validateForProject("p", "perf", map[string]interface{}{"ops": 1.0})
+		if err != nil || len(violations) != 0 {
+			t.Errorf("expected no violations for valid data, got %v (%v)", violations, err)
+		}
+		violations, err = validateForProject("p", "perf", map[string]interface{}{"ops": "fast"})
+		if err != nil || len(violations) != 1 {
+			t.Errorf("expected one violation, got %v (%v)", violations, err)
+		}
+		violations, err = validateForProject("p", "other", map[string]interface{}{"ops": "fast"})
+		if err != nil || len(violations) != 0 {
+			t.Errorf("expected no violations under another name, got %v (%v)", violations, err)
+		}
+	})
+}
+
+func TestInsertTaskSchemaViolations(t *testing.T) {
+	withMemoryStore(t, nil, func() {
+		if err := jsonStore.SetSchema(&Schema{ProjectId: "p", Name: "perf", Schema: testSchema}); err != nil {
+			t.Fatal(err)
+		}
+		r := newRequest("POST", "/data/perf", map[string]interface{}{"ops": "fast"})
+		plugin.SetTask(r, seriesTask("t1", 1))
+		w := serveRoute("/data/{name}", insertTask, r)
+		violations := SchemaViolations{}
+		if w.Code != http.StatusBadRequest || json.Unmarshal(w.Body.Bytes(), &violations) != nil {
+			t.Fatalf("expected the violations with a 400, got %v: %v", w.Code, w.Body.String())
+		}
+		if violations.Message == "" || len(violations.Errors) != 1 {
+			t.Errorf("expected a message and one error, got %+v", violations)
+		}
+		if doc, _ := jsonStore.FindByTaskId("t1", "perf"); doc != nil {
+			t.Errorf("expected the data not to be stored, got %+v", doc)
+		}
+
+		r = newRequest("POST", "/data/perf", map[string]interface{}{"ops": 1.0})
+		plugin.SetTask(r, seriesTask("t1", 1))
+		if w = serveRoute("/data/{name}", insertTask, r); w.Code != http.StatusOK {
+			t.Errorf("expected valid data to be stored, got %v: %v", w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestHandleSchema(t *testing.T) {
+	withMemoryStore(t, nil, func() {
+		settings = Settings{Projects: map[string]ProjectSettings{"p": {Admins: []string{"admin"}}}}
+
+		for _, r := range []*http.Request{
+			httptest.NewRequest("POST", "/schema/p/perf", strings.NewReader(testSchema)),
+			asUser(httptest.NewRequest("PUT", "/schema/p/perf", strings.NewReader(testSchema)), "someone"),
+			asUser(httptest.NewRequest("DELETE", "/schema/p/perf", nil), "someone"),
+			asUser(httptest.NewRequest("POST", "/schema/q/perf", strings.NewReader(testSchema)), "admin"),
+		} {
+			if w := serveUI(r); w.Code != http.StatusForbidden {
+				t.Errorf("%v %v: expected 403, got %v", r.Method, r.URL, w.Code)
+			}
+		}
+		if schema, _ := jsonStore.FindSchema("p", "perf"); schema != nil {
+			t.Fatalf("expected no schema to be registered, got %+v", schema)
+		}
+
+		r := asUser(httptest.NewRequest("POST", "/schema/p/perf", strings.NewReader(`{"type": 5}`)), "admin")
+		if w := serveUI(r); w.Code != http.StatusBadRequest {
+			t.Errorf("invalid schema: expected 400, got %v", w.Code)
+		}
+		r = asUser(httptest.NewRequest("POST", "/schema/p/perf", strings.NewReader(testSchema)), "admin")
+		if w := serveUI(r); w.Code != http.StatusOK {
+			t.Fatalf("expected the schema to be registered, got %v: %v", w.Code, w.Body.String())
+		}
+
+		w := serveUI(httptest.NewRequest("GET", "/schema/p/perf", nil))
+		registered := struct {
+			Schema map[string]interface{} `json:"schema"`
+		}{}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &registered) != nil {
+			t.Fatalf("expected the schema, got %v: %v", w.Code, w.Body.String())
+		}
+		if registered.Schema["type"] != "object" {
+			t.Errorf("expected the schema as an object, got %v", w.Body.String())
+		}
+
+		r = asUser(httptest.NewRequest("DELETE", "/schema/p/perf", nil), "admin")
+		if w = serveUI(r); w.Code != http.StatusOK {
+			t.Errorf("expected the schema to be removed, got %v", w.Code)
+		}
+		if w = serveUI(httptest.NewRequest("GET", "/schema/p/perf", nil)); w.Code != http.StatusNotFound {
+			t.Errorf("expected no schema, got %v", w.Code)
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package evgjson
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/evergreen-ci/evergreen/plugin"
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// SeriesQuery selects numbers from the non-patch history of a task's
+// document on one or more variants.
+type SeriesQuery struct {
+	ProjectId string
+	Variants  []string
+	TaskName  string
+	Name      string
+	// Paths are the dot-paths into each document's data to extract.
+	Paths []string
+}
+
+// SeriesPoint is the value of a metric at one revision.
+type SeriesPoint struct {
+	Revision   string    `json:"revision"`
+	Order      int       `json:"order"`
+	CreateTime time.Time `json:"create_time"`
+	Value      float64   `json:"value"`
+}
+
+// Series is the history of one metric on one variant, in revision order.
+type Series struct {
+	Variant string        `json:"variant"`
+	Path    string        `json:"path"`
+	Points  []SeriesPoint `json:"points"`
+}
+
+// seriesRow holds the values of every path of a SeriesQuery in one document.
+type seriesRow struct {
+	Variant    string        `bson:"variant"`
+	Revision   string        `bson:"revision"`
+	Order      int           `bson:"order"`
+	CreateTime time.Time     `bson:"create_time"`
+	Values     []interface{} `bson:"values"`
+	DataFile   string        `bson:"data_file"`
+}
+
+// groupSeries turns rows sorted by revision order into one series per
+// variant and path. Values that are missing or not numbers are skipped.
+func groupSeries(q SeriesQuery, rows []seriesRow) []Series {
+	series := []Series{}
+	index := map[string]int{}
+	for _, variant := range q.Variants {
+		for _, path := range q.Paths {
+			index[variant+"\x00"+path] = len(series)
+			series = append(series, Series{Variant: variant, Path: path, Points: []SeriesPoint{}})
+		}
+	}
+	for _, row := range rows {
+		for i, path := range q.Paths {
+			if i >= len(row.Values) {
+				break
+			}
+			value, ok := toFloat(row.Values[i])
+			if !ok {
+				continue
+			}
+			s := &series[index[row.Variant+"\x00"+path]]
+			s.Points = append(s.Points, SeriesPoint{
+				Revision:   row.Revision,
+				Order:      row.Order,
+				CreateTime: row.CreateTime,
+				Value:      value,
+			})
+		}
+	}
+	return series
+}
+
+// aggregationPath returns an aggregation expression for the value at a
+// dot-path into a document's data. It follows lookupPath: numeric segments
+// index into arrays and any other segment names a field of an object, so
+// a segment that doesn't fit the value it's applied to leaves no value
+// rather than being mapped over an array's elements.
+func aggregationPath(path string) interface{} {
+	var expr interface{} = "$" + DataKey
+	for _, segment := range splitPath(path) {
+		var inArray interface{}
+		if i, err := strconv.Atoi(segment); err == nil {
+			inArray = bson.M{"$arrayElemAt": []interface{}{"$$v", i}}
+		}
+		expr = bson.M{"$let": bson.M{
+			"vars": bson.M{"v": expr},
+			"in":   bson.M{"$cond": []interface{}{bson.M{"$isArray": "$$v"}, inArray, "$$v." + segment}},
+		}}
+	}
+	return expr
+}
+
+// getSeries sends back the history of one or more metrics of a task's
+// document. The variant in the route can be joined by others with the
+// "variant" parameter, and the metrics are given by the "path" parameter,
+// whose array indexes may not be negative.
+func getSeries(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q := SeriesQuery{
+		ProjectId: mux.Vars(r)["project_id"],
+		Variants:  []string{mux.Vars(r)["variant"]},
+		TaskName:  mux.Vars(r)["task_name"],
+		Name:      mux.Vars(r)["name"],
+	}
+	for _, variant := range r.Form["variant"] {
+		if variant != q.Variants[0] {
+			q.Variants = append(q.Variants, variant)
+		}
+	}
+	for _, path := range r.Form["path"] {
+		segments := splitPath(path)
+		for _, segment := range segments {
+			if i, err := strconv.Atoi(segment); err == nil && i < 0 {
+				http.Error(w, fmt.Sprintf("path '%v' has a negative index", path), http.StatusBadRequest)
+				return
+			}
+		}
+		if len(segments) > 0 {
+			q.Paths = append(q.Paths, path)
+		}
+	}
+	if len(q.Paths) == 0 {
+		http.Error(w, "at least one path must be given", http.StatusBadRequest)
+		return
+	}
+
+	series, err := jsonStore.FindSeries(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	plugin.WriteJSON(w, http.StatusOK, series)
+}
@@ -0,0 +1,82 @@
+package evgjson
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestGetSeries(t *testing.T) {
+	windows := seriesDoc("w2", 2, map[string]interface{}{"ops": 20.0})
+	windows.Variant = "windows"
+	patch := seriesDoc("patch", 2, map[string]interface{}{"ops": 99.0})
+	patch.IsPatch = true
+	docs := []TaskJSON{
+		seriesDoc("t1", 1, map[string]interface{}{"ops": 1.0, "results": []interface{}{map[string]interface{}{"ops": 10.0}}}),
+		seriesDoc("t2", 2, map[string]interface{}{"ops": "fast"}),
+		seriesDoc("t3", 3, map[string]interface{}{"ops": 3.0, "results": map[string]interface{}{"0": map[string]interface{}{"ops": 30.0}}}),
+		windows,
+		patch,
+	}
+
+	withMemoryStore(t, docs, func() {
+		w := serveUI(httptest.NewRequest("GET", "/series/p/linux/bench/perf?variant=windows&path=ops&path=$.results[0].ops", nil))
+		series := []Series{}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &series) != nil {
+			t.Fatalf("expected the series, got %v: %v", w.Code, w.Body.String())
+		}
+		points := map[string][]int{}
+		for _, s := range series {
+			key := s.Variant + " " + s.Path
+			points[key] = []int{}
+			for _, p := range s.Points {
+				points[key] = append(points[key], p.Order)
+			}
+		}
+		expected := map[string][]int{
+			// values that aren't numbers and patches are skipped
+			"linux ops": {1, 3},
+			// a numeric segment indexes an array or names a field of an object
+			"linux $.results[0].ops":   {1, 3},
+			"windows ops":              {2},
+			"windows $.results[0].ops": {},
+		}
+		if !reflect.DeepEqual(points, expected) {
+			t.Errorf("expected %v, got %v", expected, points)
+		}
+
+		for _, query := range []string{"", "path=", "path=results.-1.ops", "path=$.results[-1]"} {
+			w = serveUI(httptest.NewRequest("GET", "/series/p/linux/bench/perf?"+query, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%v: expected 400, got %v", query, w.Code)
+			}
+		}
+	})
+}
+
+func TestAggregationPath(t *testing.T) {
+	expected := map[string]interface{}{"$let": map[string]interface{}{
+		"vars": map[string]interface{}{"v": map[string]interface{}{"$let": map[string]interface{}{
+			"vars": map[string]interface{}{"v": "$data"},
+			"in":   map[string]interface{}{"$cond": []interface{}{map[string]interface{}{"$isArray": "$$v"}, nil, "$$v.results"}},
+		}}},
+		"in": map[string]interface{}{"$cond": []interface{}{
+			map[string]interface{}{"$isArray": "$$v"},
+			map[string]interface{}{"$arrayElemAt": []interface{}{"$$v", 0.0}},
+			"$$v.0",
+		}},
+	}}
+	b, err := json.Marshal(aggregationPath("results.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expr interface{}
+	if err = json.Unmarshal(b, &expr); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expr, expected) {
+		t.Errorf("expected %v, got %v", expected, expr)
+	}
+}
@@ -0,0 +1,144 @@
+package evgjson
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/evergreen-ci/evergreen/plugin"
+	"github.com/mitchellh/mapstructure"
+)
+
+// Settings is the plugin's section of evergreen's settings, e.g.
+//
+//	plugins:
+//	  json:
+//	    admins: ["alice"]
+//	    projects:
+//	      mongodb-mongo-master:
+//	        protected_tags: ["release-*", "baseline"]
+//	        retention:
+//	          patch_days: 30
+//	          mainline_days: 365
+//	        metrics:
+//	          - name: perf
+//	            paths: ["ops_per_sec", "latency.p99"]
+type Settings struct {
+	// Admins may override tag protections, register schemas, set baselines
+	// and see retention reports in every project.
+	Admins   []string                   `mapstructure:"admins"`
+	Projects map[string]ProjectSettings `mapstructure:"projects"`
+	// PruneIntervalMinutes is how often retention policies are applied.
+	PruneIntervalMinutes int `mapstructure:"prune_interval_minutes"`
+	// MaxPayloadBytes is the size of the largest document that may be
+	// stored. It defaults to the database's limit on a document's size.
+	MaxPayloadBytes int64 `mapstructure:"max_payload_bytes"`
+	// ChunkThresholdBytes is the size above which a document's data is
+	// kept in GridFS rather than in the document itself, or 0 to never
+	// do so. It must be set to store documents near or above the
+	// database's limit.
+	ChunkThresholdBytes int `mapstructure:"chunk_threshold_bytes"`
+}
+
+// ProjectSettings configures the plugin for one project.
+type ProjectSettings struct {
+	// ProtectedTags are patterns, in the syntax of path.Match, for tags
+	// that may not be moved to another version or removed once set unless
+	// an admin forces it.
+	ProtectedTags []string `mapstructure:"protected_tags"`
+	// Admins may override tag protections, register schemas, set baselines
+	// and see retention reports in this project.
+	Admins []string `mapstructure:"admins"`
+	// Retention says how long the project's documents are kept.
+	Retention RetentionPolicy `mapstructure:"retention"`
+	// Metrics are the paths whose latest mainline values are exposed to
+	// monitoring.
+	Metrics []MetricPaths `mapstructure:"metrics"`
+}
+
+// maxDocumentBytes is the database's limit on the size of a document.
+const maxDocumentBytes = 16 * 1024 * 1024
+
+// settings holds the configuration passed to Configure.
+var settings = Settings{}
+
+// parseSettings reads and checks the plugin's configuration.
+func parseSettings(conf map[string]interface{}) (Settings, error) {
+	s := Settings{}
+	if err := mapstructure.Decode(conf, &s); err != nil {
+		return Settings{}, err
+	}
+	if s.MaxPayloadBytes < 0 || s.ChunkThresholdBytes < 0 {
+		return Settings{}, fmt.Errorf("size limits must not be negative")
+	}
+	for projectId, project := range s.Projects {
+		if project.Retention.PatchDays < 0 || project.Retention.MainlineDays < 0 {
+			return Settings{}, fmt.Errorf("retention for project '%v' must not be negative", projectId)
+		}
+		for _, metric := range project.Metrics {
+			if metric.Name == "" || len(metric.Paths) == 0 {
+				return Settings{}, fmt.Errorf("metrics for project '%v' must have a name and paths", projectId)
+			}
+		}
+		for _, pattern := range project.ProtectedTags {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return Settings{}, fmt.Errorf("invalid protected tag pattern '%v' for project '%v': %v",
+					pattern, projectId, err)
+			}
+		}
+	}
+	return s, nil
+}
+
+// maxPayloadBytes returns the size of the largest document that may be stored.
+func (s Settings) maxPayloadBytes() int64 {
+	if s.MaxPayloadBytes > 0 {
+		return s.MaxPayloadBytes
+	}
+	return maxDocumentBytes
+}
+
+// hasRetention returns whether any project has a retention policy.
+func (s Settings) hasRetention() bool {
+	for _, project := range s.Projects {
+		if project.Retention.PatchDays > 0 || project.Retention.MainlineDays > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// isProtectedTag returns whether a tag matches one of a project's protected patterns.
+func isProtectedTag(projectId, tag string) bool {
+	for _, pattern := range settings.Projects[projectId].ProtectedTags {
+		if matched, _ := path.Match(pattern, tag); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// isAdmin returns whether a user is an admin of a project.
+func isAdmin(projectId, username string) bool {
+	if username == "" {
+		return false
+	}
+	admins := append(append([]string{}, settings.Admins...), settings.Projects[projectId].Admins...)
+	for _, admin := range admins {
+		if admin == username {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAdmin sends back a 403 and returns false unless the user making a
+// request is an admin of a project. action describes what they asked to do.
+func checkAdmin(w http.ResponseWriter, r *http.Request, projectId, action string) bool {
+	u := plugin.GetUser(r)
+	if u == nil || !isAdmin(projectId, u.Username()) {
+		http.Error(w, fmt.Sprintf("only an admin may %v", action), http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,177 @@
+package evgjson
+
+import "time"
+
+// SeriesKey identifies the documents that one task produces under a
+// single name on one variant of a project, across all of its revisions.
+type SeriesKey struct {
+	ProjectId string
+	Variant   string
+	TaskName  string
+	Name      string
+}
+
+// HistoryQuery describes a window of non-patch documents for a series
+// centered on a revision order number.
+type HistoryQuery struct {
+	SeriesKey
+	// Order is the revision order number the window is centered on.
+	Order int
+	// Before is the maximum number of documents to return with an order
+	// less than or equal to Order.
+	Before int
+	// After is the maximum number of documents to return with an order
+	// greater than Order.
+	After int
+	// Fields are the paths into each document's data to return, or nil
+	// for all of it.
+	Fields []string
+}
+
+// HistoryRange selects a page of the non-patch documents for a series by
+// revision order number and creation time. Zero values leave a bound open.
+type HistoryRange struct {
+	SeriesKey
+	// FromOrder and ToOrder bound the revision order number, inclusive.
+	FromOrder int
+	ToOrder   int
+	// Start and End bound the creation time, inclusive.
+	Start time.Time
+	End   time.Time
+	// AfterOrder only selects documents with a greater revision order
+	// number, to continue from the end of a previous page.
+	AfterOrder int
+	// Limit is the maximum number of documents to return, or zero for all of them.
+	Limit int
+	// Fields are the paths into each document's data to return, or nil
+	// for all of it.
+	Fields []string
+}
+
+// JSONStore is the storage backend for TaskJSON documents. Lookups that
+// match a single document return nil and no error when nothing is found.
+// Lookups that take fields return only those dot-paths into each
+// document's data, or all of it when no fields are given. A path through
+// an array applies to every object in the array; fields cannot index into
+// an array, and callers cut them off before any numeric segment.
+type JSONStore interface {
+	// Insert creates the document for doc's task id, name and execution,
+	// replacing any document that already exists for them. It returns
+	// errPayloadTooLarge if the document is too large to store.
+	Insert(doc *TaskJSON) error
+	// Merge deep-merges the data of doc, which must be an object, into the
+	// document for doc's task id and name, creating it if it does not exist.
+	Merge(doc *TaskJSON) error
+	// Append adds the data of doc to the end of the array in the document
+	// for doc's task id and name, creating it if it does not exist. The
+	// elements of an array are added individually.
+	//
+	// Merge and Append are atomic: of two made at once, neither loses
+	// the other's data. They return errPayloadTooLarge if the combined
+	// document is too large to store, and errNotUpdatable if the stored
+	// data is kept in GridFS.
+	Append(doc *TaskJSON) error
+
+	// FindByTaskId returns the document a task's latest execution stored
+	// under name.
+	FindByTaskId(taskId, name string, fields ...string) (*TaskJSON, error)
+	// FindByTaskExecution returns the document one execution of a task
+	// stored under name.
+	FindByTaskExecution(taskId, name string, execution int, fields ...string) (*TaskJSON, error)
+	// FindByBuild returns the document stored under name by the task
+	// called taskName in a build of a version.
+	FindByBuild(versionId, buildId, taskName, name string, fields ...string) (*TaskJSON, error)
+	// FindByVersion returns every document stored under name in a version.
+	FindByVersion(versionId, name string, fields ...string) ([]TaskJSON, error)
+	// FindLatest returns the latest mainline document stored under name by
+	// each variant and task in a project.
+	FindLatest(projectId, name string, fields ...string) ([]TaskJSON, error)
+	// FindLatestVersionId returns the id of the version with the highest
+	// revision order number that has a document stored under name, or ""
+	// if there is none.
+	FindLatestVersionId(projectId, name string) (string, error)
+	// FindByRevision returns the non-patch document for a series at the
+	// commit whose hash starts with revision, ignoring case.
+	FindByRevision(key SeriesKey, revision string, fields ...string) (*TaskJSON, error)
+	// FindHistory returns the documents in a history window, sorted by
+	// ascending revision order number.
+	FindHistory(q HistoryQuery) ([]TaskJSON, error)
+	// FindHistoryRange returns the documents in a history range, sorted by
+	// ascending revision order number.
+	FindHistoryRange(q HistoryRange) ([]TaskJSON, error)
+	// IterByVersion, IterHistory and IterHistoryRange pass the documents
+	// FindByVersion, FindHistory and FindHistoryRange return to each, one
+	// at a time as they are read, so they need not all be held in memory.
+	// They stop at the first error each returns.
+	IterByVersion(versionId, name string, fields []string, each func(*TaskJSON) error) error
+	IterHistory(q HistoryQuery, each func(*TaskJSON) error) error
+	IterHistoryRange(q HistoryRange, each func(*TaskJSON) error) error
+	// FindSeries returns one series for each variant and path of the query.
+	FindSeries(q SeriesQuery) ([]Series, error)
+
+	// FindTagged returns every document in a series that has a tag.
+	FindTagged(key SeriesKey, fields ...string) ([]TaskJSON, error)
+	// IterTagged passes the documents FindTagged returns to each, one at a
+	// time as they are read, stopping at the first error each returns.
+	IterTagged(key SeriesKey, fields []string, each func(*TaskJSON) error) error
+	// FindByTag returns the document in a series that has the given tag
+	// among its tags.
+	FindByTag(key SeriesKey, tag string, fields ...string) (*TaskJSON, error)
+	// AddTag adds a tag to every document stored under name in a version,
+	// replacing the metadata of the tag if a document already has it.
+	AddTag(versionId, name string, tag TagInfo) error
+	// RemoveTag removes a tag from every document stored under name in a version.
+	RemoveTag(versionId, name, tag string) error
+	// FindTaggedVersionIds returns the ids of the versions in a project
+	// with a document stored under name that has the given tag.
+	FindTaggedVersionIds(projectId, name, tag string) ([]string, error)
+	// ListTags returns the distinct tags used in a project with the
+	// number of documents carrying each.
+	ListTags(projectId string) ([]TagCount, error)
+	// MigrateTags converts the bare tag names of documents stored before
+	// tags had metadata into tags without an author. It only scans the
+	// documents until it has finished once.
+	MigrateTags() error
+	// RecordTagEvent adds an entry to the tag history.
+	RecordTagEvent(event *TagEvent) error
+	// FindTagHistory returns the entries of a project's tag history.
+	FindTagHistory(q TagHistoryQuery) ([]TagEvent, error)
+
+	// SetBaseline sets the baseline of a series, replacing any existing one.
+	SetBaseline(baseline *Baseline) error
+	// FindBaseline returns the baseline of a series.
+	FindBaseline(key SeriesKey) (*Baseline, error)
+	// RemoveBaseline unsets the baseline of a series.
+	RemoveBaseline(key SeriesKey) error
+	// FindBaselines returns every baseline in a project.
+	FindBaselines(projectId string) ([]Baseline, error)
+
+	// FindPrunable returns the metadata, without the data, of the
+	// documents selected by a prune query.
+	FindPrunable(q PruneQuery) ([]TaskJSON, error)
+	// RemovePrunable removes the documents selected by a prune query,
+	// returning how many were removed.
+	RemovePrunable(q PruneQuery) (int, error)
+
+	// ListIndexes returns the key of each index on a collection.
+	ListIndexes(coll string) ([][]string, error)
+	// EnsureIndex creates an index if it does not exist.
+	EnsureIndex(index IndexSpec) error
+
+	// SetSchema registers a schema, replacing any existing schema for
+	// its project and name.
+	SetSchema(schema *Schema) error
+	// FindSchema returns the schema registered for a project and name.
+	FindSchema(projectId, name string) (*Schema, error)
+	// RemoveSchema unregisters the schema for a project and name.
+	RemoveSchema(projectId, name string) error
+}
+
+// jsonStore is the backend used by all of the plugin's handlers.
+var jsonStore JSONStore = &mgoStore{}
+
+// SetStore replaces the backend used by the plugin's handlers, for example
+// with an in-memory store in tests.
+func SetStore(s JSONStore) {
+	jsonStore = s
+}
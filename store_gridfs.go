@@ -0,0 +1,98 @@
+package evgjson
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/evergreen-ci/evergreen/db"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// gridFSPrefix is the GridFS bucket that holds the data of documents too
+// large to store in the json collection.
+const gridFSPrefix = "json"
+
+// chunkData returns the document to store for doc. If doc is larger than
+// the configured threshold or the database's limit, its data is written to
+// GridFS and the document returned refers to the file instead. If chunking
+// is off, a document over the database's limit is refused with
+// errPayloadTooLarge. Sizes are of the bson encoding, which the limit
+// applies to and which may be larger than the json that was sent.
+func chunkData(doc *TaskJSON) (*TaskJSON, error) {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	if settings.ChunkThresholdBytes <= 0 {
+		if len(raw) > maxDocumentBytes {
+			return nil, errPayloadTooLarge{maxDocumentBytes}
+		}
+		return doc, nil
+	}
+	if len(raw) <= settings.ChunkThresholdBytes && len(raw) <= maxDocumentBytes {
+		return doc, nil
+	}
+
+	data, err := json.Marshal(doc.Data)
+	if err != nil {
+		return nil, err
+	}
+	fileName := fmt.Sprintf("%v/%v/%v", doc.TaskId, doc.Name, bson.NewObjectId().Hex())
+	if err = db.WriteGridFile(gridFSPrefix, fileName, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	chunked := *doc
+	chunked.Data = nil
+	chunked.DataFile = fileName
+	return &chunked, nil
+}
+
+// errNotUpdatable is returned for a merge or append into a document whose
+// data is kept in GridFS, which can't be updated in place.
+type errNotUpdatable struct {
+	name string
+}
+
+func (e errNotUpdatable) Error() string {
+	return fmt.Sprintf("the data stored for '%v' is too large to be updated", e.name)
+}
+
+// loadDataFile reads the data of a chunked document back from GridFS,
+// limited to the given paths. It does nothing for other documents.
+func loadDataFile(doc *TaskJSON, fields []string) error {
+	if doc.DataFile == "" {
+		return nil
+	}
+	file, err := db.GetGridFile(gridFSPrefix, doc.DataFile)
+	if err != nil {
+		return fmt.Errorf("couldn't read data file '%v': %v", doc.DataFile, err)
+	}
+	defer file.Close()
+	var data interface{}
+	if err = json.NewDecoder(file).Decode(&data); err != nil {
+		return fmt.Errorf("couldn't decode data file '%v': %v", doc.DataFile, err)
+	}
+	doc.Data = projectData(data, fields)
+	return nil
+}
+
+// removeDataFiles removes the GridFS files of chunked documents.
+func removeDataFiles(fileNames []string) error {
+	if len(fileNames) == 0 {
+		return nil
+	}
+	session, database, err := db.GetGlobalSessionFactory().GetSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	gridFS := database.GridFS(gridFSPrefix)
+	for _, fileName := range fileNames {
+		if err = gridFS.Remove(fileName); err != nil && err != mgo.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
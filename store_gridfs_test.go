@@ -0,0 +1,28 @@
+package evgjson
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChunkData(t *testing.T) {
+	withMemoryStore(t, nil, func() {
+		small := seriesDoc("t1", 1, map[string]interface{}{"ops": 1.0})
+		for _, threshold := range []int{0, 1024} {
+			settings = Settings{ChunkThresholdBytes: threshold}
+			doc, err := chunkData(&small)
+			if err != nil || doc != &small {
+				t.Errorf("threshold %v: expected a small document to be stored as it is, got %+v (%v)", threshold, doc, err)
+			}
+		}
+
+		// without chunking, the database's limit can't be passed
+		settings = Settings{}
+		large := seriesDoc("t1", 1, map[string]interface{}{"log": strings.Repeat("x", maxDocumentBytes)})
+		if _, err := chunkData(&large); err == nil {
+			t.Errorf("expected a document over the database's limit to be refused")
+		} else if _, ok := err.(errPayloadTooLarge); !ok {
+			t.Errorf("expected errPayloadTooLarge, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,566 @@
+package evgjson
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// memoryStore is a JSONStore that keeps documents in a slice. It is meant
+// for tests and for running the plugin's routes without a database.
+type memoryStore struct {
+	mu         sync.RWMutex
+	docs       []TaskJSON
+	schemas    []Schema
+	tagHistory []TagEvent
+	baselines  []Baseline
+	indexes    []IndexSpec
+}
+
+// NewMemoryStore returns an empty JSONStore that keeps its documents in memory.
+func NewMemoryStore() JSONStore {
+	return &memoryStore{}
+}
+
+// filter returns copies of the documents for which match returns true.
+// Callers must hold the lock.
+func (s *memoryStore) filter(match func(*TaskJSON) bool) []TaskJSON {
+	out := []TaskJSON{}
+	for i := range s.docs {
+		if match(&s.docs[i]) {
+			out = append(out, s.docs[i])
+		}
+	}
+	return out
+}
+
+func (s *memoryStore) findOne(match func(*TaskJSON) bool, fields []string) *TaskJSON {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for i := range s.docs {
+		if match(&s.docs[i]) {
+			doc := s.docs[i]
+			doc.Data = projectData(doc.Data, fields)
+			return &doc
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) findAll(match func(*TaskJSON) bool, fields []string) []TaskJSON {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	docs := s.filter(match)
+	for i := range docs {
+		docs[i].Data = projectData(docs[i].Data, fields)
+	}
+	return docs
+}
+
+func (key SeriesKey) matches(doc *TaskJSON) bool {
+	return doc.ProjectId == key.ProjectId && doc.Variant == key.Variant &&
+		doc.TaskName == key.TaskName && doc.Name == key.Name && !doc.Superseded
+}
+
+// put stores doc in place of the document for its task id, name and
+// execution, or adds it, and marks whichever of it and the documents from
+// the task's other executions are superseded. Callers must hold the lock.
+func (s *memoryStore) put(doc TaskJSON) {
+	doc.Superseded = false
+	index := -1
+	for i := range s.docs {
+		other := &s.docs[i]
+		if other.TaskId != doc.TaskId || other.Name != doc.Name {
+			continue
+		}
+		switch {
+		case other.Execution == doc.Execution:
+			index = i
+		case other.Execution > doc.Execution:
+			doc.Superseded = true
+		default:
+			other.Superseded = true
+		}
+	}
+	if index < 0 {
+		s.docs = append(s.docs, doc)
+		return
+	}
+	s.docs[index] = doc
+}
+
+func (s *memoryStore) Insert(doc *TaskJSON) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(*doc)
+	return nil
+}
+
+// combine stores doc's data combined with the data already stored for
+// its task id, name and execution in a mode.
+func (s *memoryStore) combine(mode string, doc *TaskJSON) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	updated := *doc
+	var existing interface{}
+	for i := range s.docs {
+		if s.docs[i].TaskId == doc.TaskId && s.docs[i].Name == doc.Name && s.docs[i].Execution == doc.Execution {
+			existing = s.docs[i].Data
+			updated.Tags = s.docs[i].Tags
+		}
+	}
+	data, err := combineData(mode, existing, doc.Data)
+	if err != nil {
+		return err
+	}
+	updated.Data = data
+	s.put(updated)
+	return nil
+}
+
+func (s *memoryStore) Merge(doc *TaskJSON) error {
+	return s.combine(MergeMode, doc)
+}
+
+func (s *memoryStore) Append(doc *TaskJSON) error {
+	return s.combine(AppendMode, doc)
+}
+
+func (s *memoryStore) FindByTaskId(taskId, name string, fields ...string) (*TaskJSON, error) {
+	return s.findOne(func(doc *TaskJSON) bool {
+		return doc.TaskId == taskId && doc.Name == name && !doc.Superseded
+	}, fields), nil
+}
+
+func (s *memoryStore) FindByTaskExecution(taskId, name string, execution int, fields ...string) (*TaskJSON, error) {
+	return s.findOne(func(doc *TaskJSON) bool {
+		return doc.TaskId == taskId && doc.Name == name && doc.Execution == execution
+	}, fields), nil
+}
+
+func (s *memoryStore) FindByBuild(versionId, buildId, taskName, name string, fields ...string) (*TaskJSON, error) {
+	return s.findOne(func(doc *TaskJSON) bool {
+		return doc.VersionId == versionId && doc.BuildId == buildId &&
+			doc.TaskName == taskName && doc.Name == name && !doc.Superseded
+	}, fields), nil
+}
+
+func (s *memoryStore) FindByVersion(versionId, name string, fields ...string) ([]TaskJSON, error) {
+	return s.findAll(func(doc *TaskJSON) bool {
+		return doc.VersionId == versionId && doc.Name == name && !doc.Superseded
+	}, fields), nil
+}
+
+// iterDocs passes documents to each in turn, stopping at the first error.
+func iterDocs(docs []TaskJSON, each func(*TaskJSON) error) error {
+	for i := range docs {
+		if err := each(&docs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) IterByVersion(versionId, name string, fields []string, each func(*TaskJSON) error) error {
+	docs, _ := s.FindByVersion(versionId, name, fields...)
+	return iterDocs(docs, each)
+}
+
+func (s *memoryStore) FindLatest(projectId, name string, fields ...string) ([]TaskJSON, error) {
+	docs := s.findAll(func(doc *TaskJSON) bool {
+		return doc.ProjectId == projectId && doc.Name == name && !doc.IsPatch && !doc.Superseded
+	}, fields)
+	latest := map[[2]string]TaskJSON{}
+	for _, doc := range docs {
+		key := [2]string{doc.Variant, doc.TaskName}
+		if current, ok := latest[key]; !ok || doc.RevisionOrderNumber > current.RevisionOrderNumber {
+			latest[key] = doc
+		}
+	}
+	out := make([]TaskJSON, 0, len(latest))
+	for _, doc := range latest {
+		out = append(out, doc)
+	}
+	return out, nil
+}
+
+func (s *memoryStore) FindLatestVersionId(projectId, name string) (string, error) {
+	docs := s.findAll(func(doc *TaskJSON) bool {
+		return doc.ProjectId == projectId && doc.Name == name && !doc.Superseded
+	}, nil)
+	latest := -1
+	versionId := ""
+	for _, doc := range docs {
+		if doc.RevisionOrderNumber > latest {
+			latest = doc.RevisionOrderNumber
+			versionId = doc.VersionId
+		}
+	}
+	return versionId, nil
+}
+
+func (s *memoryStore) FindByRevision(key SeriesKey, revision string, fields ...string) (*TaskJSON, error) {
+	return s.findOne(func(doc *TaskJSON) bool {
+		return key.matches(doc) && !doc.IsPatch &&
+			strings.HasPrefix(strings.ToLower(doc.Revision), strings.ToLower(revision))
+	}, fields), nil
+}
+
+func (s *memoryStore) FindHistory(q HistoryQuery) ([]TaskJSON, error) {
+	before := s.findAll(func(doc *TaskJSON) bool {
+		return q.matches(doc) && !doc.IsPatch && doc.RevisionOrderNumber <= q.Order
+	}, q.Fields)
+	sortByOrder(before)
+	if len(before) > q.Before {
+		before = before[len(before)-q.Before:]
+	}
+
+	after := s.findAll(func(doc *TaskJSON) bool {
+		return q.matches(doc) && !doc.IsPatch && doc.RevisionOrderNumber > q.Order
+	}, q.Fields)
+	sortByOrder(after)
+	if len(after) > q.After {
+		after = after[:q.After]
+	}
+	return append(before, after...), nil
+}
+
+func (s *memoryStore) IterHistory(q HistoryQuery, each func(*TaskJSON) error) error {
+	docs, _ := s.FindHistory(q)
+	return iterDocs(docs, each)
+}
+
+func (s *memoryStore) FindHistoryRange(q HistoryRange) ([]TaskJSON, error) {
+	page := s.findAll(func(doc *TaskJSON) bool {
+		return q.matches(doc) && !doc.IsPatch &&
+			(q.FromOrder <= 0 || doc.RevisionOrderNumber >= q.FromOrder) &&
+			(q.ToOrder <= 0 || doc.RevisionOrderNumber <= q.ToOrder) &&
+			(q.AfterOrder <= 0 || doc.RevisionOrderNumber > q.AfterOrder) &&
+			(q.Start.IsZero() || !doc.CreateTime.Before(q.Start)) &&
+			(q.End.IsZero() || !doc.CreateTime.After(q.End))
+	}, q.Fields)
+	sortByOrder(page)
+	if q.Limit > 0 && len(page) > q.Limit {
+		page = page[:q.Limit]
+	}
+	return page, nil
+}
+
+func (s *memoryStore) IterHistoryRange(q HistoryRange, each func(*TaskJSON) error) error {
+	page, _ := s.FindHistoryRange(q)
+	return iterDocs(page, each)
+}
+
+func (s *memoryStore) FindSeries(q SeriesQuery) ([]Series, error) {
+	variants := map[string]bool{}
+	for _, variant := range q.Variants {
+		variants[variant] = true
+	}
+	docs := s.findAll(func(doc *TaskJSON) bool {
+		return doc.ProjectId == q.ProjectId && variants[doc.Variant] &&
+			doc.TaskName == q.TaskName && doc.Name == q.Name && !doc.IsPatch && !doc.Superseded
+	}, nil)
+	sortByOrder(docs)
+	rows := make([]seriesRow, 0, len(docs))
+	for _, doc := range docs {
+		row := seriesRow{
+			Variant:    doc.Variant,
+			Revision:   doc.Revision,
+			Order:      doc.RevisionOrderNumber,
+			CreateTime: doc.CreateTime,
+		}
+		for _, path := range q.Paths {
+			value, _ := lookupPath(doc.Data, path)
+			row.Values = append(row.Values, value)
+		}
+		rows = append(rows, row)
+	}
+	return groupSeries(q, rows), nil
+}
+
+func hasTag(doc *TaskJSON, tag string) bool {
+	for _, t := range doc.Tags {
+		if t.Name == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memoryStore) FindTagged(key SeriesKey, fields ...string) ([]TaskJSON, error) {
+	return s.findAll(func(doc *TaskJSON) bool {
+		return key.matches(doc) && len(doc.Tags) > 0
+	}, fields), nil
+}
+
+func (s *memoryStore) IterTagged(key SeriesKey, fields []string, each func(*TaskJSON) error) error {
+	docs, _ := s.FindTagged(key, fields...)
+	return iterDocs(docs, each)
+}
+
+func (s *memoryStore) FindByTag(key SeriesKey, tag string, fields ...string) (*TaskJSON, error) {
+	return s.findOne(func(doc *TaskJSON) bool {
+		return key.matches(doc) && hasTag(doc, tag)
+	}, fields), nil
+}
+
+// updateTags replaces the tags of every document stored under name in a version.
+func (s *memoryStore) updateTags(versionId, name string, update func([]TagInfo) []TagInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.docs {
+		if s.docs[i].VersionId == versionId && s.docs[i].Name == name {
+			s.docs[i].Tags = update(s.docs[i].Tags)
+		}
+	}
+}
+
+func (s *memoryStore) AddTag(versionId, name string, tag TagInfo) error {
+	s.updateTags(versionId, name, func(tags []TagInfo) []TagInfo {
+		updated := append([]TagInfo{}, tags...)
+		for i := range updated {
+			if updated[i].Name == tag.Name {
+				updated[i] = tag
+				return updated
+			}
+		}
+		return append(updated, tag)
+	})
+	return nil
+}
+
+func (s *memoryStore) RemoveTag(versionId, name, tag string) error {
+	s.updateTags(versionId, name, func(tags []TagInfo) []TagInfo {
+		kept := []TagInfo{}
+		for _, t := range tags {
+			if t.Name != tag {
+				kept = append(kept, t)
+			}
+		}
+		return kept
+	})
+	return nil
+}
+
+func (s *memoryStore) FindTaggedVersionIds(projectId, name, tag string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	seen := map[string]bool{}
+	versionIds := []string{}
+	for i := range s.docs {
+		doc := &s.docs[i]
+		if doc.ProjectId == projectId && doc.Name == name && !doc.Superseded && hasTag(doc, tag) &&
+			!seen[doc.VersionId] {
+			seen[doc.VersionId] = true
+			versionIds = append(versionIds, doc.VersionId)
+		}
+	}
+	return versionIds, nil
+}
+
+func (s *memoryStore) ListTags(projectId string) ([]TagCount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	counts := map[string]int{}
+	for _, doc := range s.docs {
+		if doc.ProjectId != projectId || doc.Superseded {
+			continue
+		}
+		for _, tag := range doc.Tags {
+			counts[tag.Name]++
+		}
+	}
+	tags := make([]TagCount, 0, len(counts))
+	for tag, count := range counts {
+		tags = append(tags, TagCount{Tag: tag, Count: count})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Tag < tags[j].Tag })
+	return tags, nil
+}
+
+// MigrateTags does nothing, since a memoryStore never held bare tag names.
+func (s *memoryStore) MigrateTags() error {
+	return nil
+}
+
+func (s *memoryStore) RecordTagEvent(event *TagEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tagHistory = append(s.tagHistory, *event)
+	return nil
+}
+
+func (s *memoryStore) FindTagHistory(q TagHistoryQuery) ([]TagEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	events := []TagEvent{}
+	// walk backwards, since events are recorded oldest first
+	for i := len(s.tagHistory) - 1; i >= 0; i-- {
+		event := s.tagHistory[i]
+		if event.ProjectId != q.ProjectId || (q.Name != "" && event.Name != q.Name) ||
+			(q.Tag != "" && event.Tag != q.Tag) {
+			continue
+		}
+		events = append(events, event)
+		if q.Limit > 0 && len(events) == q.Limit {
+			break
+		}
+	}
+	return events, nil
+}
+
+func (key SeriesKey) matchesBaseline(baseline *Baseline) bool {
+	return baseline.ProjectId == key.ProjectId && baseline.Variant == key.Variant &&
+		baseline.TaskName == key.TaskName && baseline.Name == key.Name
+}
+
+func (s *memoryStore) SetBaseline(baseline *Baseline) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := SeriesKey{ProjectId: baseline.ProjectId, Variant: baseline.Variant,
+		TaskName: baseline.TaskName, Name: baseline.Name}
+	for i := range s.baselines {
+		if key.matchesBaseline(&s.baselines[i]) {
+			s.baselines[i] = *baseline
+			return nil
+		}
+	}
+	s.baselines = append(s.baselines, *baseline)
+	return nil
+}
+
+func (s *memoryStore) FindBaseline(key SeriesKey) (*Baseline, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, baseline := range s.baselines {
+		if key.matchesBaseline(&baseline) {
+			return &baseline, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *memoryStore) RemoveBaseline(key SeriesKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.baselines {
+		if key.matchesBaseline(&s.baselines[i]) {
+			s.baselines = append(s.baselines[:i], s.baselines[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) FindBaselines(projectId string) ([]Baseline, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	baselines := []Baseline{}
+	for _, baseline := range s.baselines {
+		if baseline.ProjectId == projectId {
+			baselines = append(baselines, baseline)
+		}
+	}
+	return baselines, nil
+}
+
+func (q PruneQuery) matches(doc *TaskJSON) bool {
+	if doc.ProjectId != q.ProjectId || doc.IsPatch != q.IsPatch || !doc.CreateTime.Before(q.CreatedBefore) ||
+		len(doc.Tags) > 0 {
+		return false
+	}
+	for _, baseline := range q.Baselines {
+		if doc.TaskId == baseline.TaskId && doc.Name == baseline.Name {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *memoryStore) FindPrunable(q PruneQuery) ([]TaskJSON, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	docs := s.filter(q.matches)
+	for i := range docs {
+		docs[i].Data = nil
+	}
+	sort.SliceStable(docs, func(i, j int) bool { return docs[i].CreateTime.Before(docs[j].CreateTime) })
+	return docs, nil
+}
+
+func (s *memoryStore) RemovePrunable(q PruneQuery) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := []TaskJSON{}
+	for i := range s.docs {
+		if !q.matches(&s.docs[i]) {
+			kept = append(kept, s.docs[i])
+		}
+	}
+	removed := len(s.docs) - len(kept)
+	s.docs = kept
+	return removed, nil
+}
+
+func (s *memoryStore) ListIndexes(coll string) ([][]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := [][]string{}
+	for _, index := range s.indexes {
+		if index.Collection == coll {
+			keys = append(keys, index.Key)
+		}
+	}
+	return keys, nil
+}
+
+// EnsureIndex only records the index, since a memoryStore always scans.
+func (s *memoryStore) EnsureIndex(index IndexSpec) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.indexes = append(s.indexes, index)
+	return nil
+}
+
+func (s *memoryStore) SetSchema(schema *Schema) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.schemas {
+		if s.schemas[i].ProjectId == schema.ProjectId && s.schemas[i].Name == schema.Name {
+			s.schemas[i] = *schema
+			return nil
+		}
+	}
+	s.schemas = append(s.schemas, *schema)
+	return nil
+}
+
+func (s *memoryStore) FindSchema(projectId, name string) (*Schema, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, schema := range s.schemas {
+		if schema.ProjectId == projectId && schema.Name == name {
+			return &schema, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *memoryStore) RemoveSchema(projectId, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.schemas {
+		if s.schemas[i].ProjectId == projectId && s.schemas[i].Name == name {
+			s.schemas = append(s.schemas[:i], s.schemas[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// sortByOrder sorts documents by ascending revision order number.
+func sortByOrder(docs []TaskJSON) {
+	sort.SliceStable(docs, func(i, j int) bool {
+		return docs[i].RevisionOrderNumber < docs[j].RevisionOrderNumber
+	})
+}
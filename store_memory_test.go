@@ -0,0 +1,198 @@
+package evgjson
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/evergreen-ci/evergreen/model/task"
+	"github.com/gorilla/mux"
+)
+
+// withMemoryStore runs test against a new memory store holding docs,
+// restoring the plugin's store and settings once it returns.
+func withMemoryStore(t *testing.T, docs []TaskJSON, test func()) {
+	oldStore, oldSettings := jsonStore, settings
+	defer func() {
+		SetStore(oldStore)
+		settings = oldSettings
+	}()
+	SetStore(NewMemoryStore())
+	for i := range docs {
+		if err := jsonStore.Insert(&docs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	test()
+}
+
+// seriesDoc returns a mainline document in the "perf" series of the
+// "bench" task on the "linux" variant of project "p".
+func seriesDoc(taskId string, order int, data interface{}) TaskJSON {
+	return TaskJSON{
+		Name:                "perf",
+		TaskId:              taskId,
+		TaskName:            "bench",
+		ProjectId:           "p",
+		Variant:             "linux",
+		VersionId:           fmt.Sprintf("v%v", order),
+		Revision:            fmt.Sprintf("r%v", order),
+		RevisionOrderNumber: order,
+		CreateTime:          time.Date(2016, 1, order, 0, 0, 0, 0, time.UTC),
+		Data:                data,
+	}
+}
+
+// seriesTask returns the mainline task that stored seriesDoc(taskId, order, ...).
+func seriesTask(taskId string, order int) *task.Task {
+	return &task.Task{
+		Id:                  taskId,
+		DisplayName:         "bench",
+		Project:             "p",
+		BuildVariant:        "linux",
+		Version:             fmt.Sprintf("v%v", order),
+		Revision:            fmt.Sprintf("r%v", order),
+		RevisionOrderNumber: order,
+	}
+}
+
+// newRequest returns a request whose body, unless it is nil, is body
+// encoded as json.
+func newRequest(method, url string, body interface{}) *http.Request {
+	var b []byte
+	if body != nil {
+		b, _ = json.Marshal(body)
+	}
+	return httptest.NewRequest(method, url, bytes.NewReader(b))
+}
+
+// serveUI sends a request to the plugin's UI routes.
+func serveUI(r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	(&JSONPlugin{}).GetUIHandler().ServeHTTP(w, r)
+	return w
+}
+
+// serveRoute sends a request to a handler for a route, which may hold
+// variables like the plugin's own routes.
+func serveRoute(route string, handler http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	router := mux.NewRouter()
+	router.HandleFunc(route, handler)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+// forTask returns a handler that runs a handler taking a task with t.
+func forTask(t *task.Task, handler func(*task.Task, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(t, w, r)
+	}
+}
+
+// orders returns the revision order numbers of docs.
+func orders(docs []TaskJSON) []int {
+	out := []int{}
+	for _, doc := range docs {
+		out = append(out, doc.RevisionOrderNumber)
+	}
+	return out
+}
+
+func TestMemoryStoreInsert(t *testing.T) {
+	withMemoryStore(t, []TaskJSON{seriesDoc("t1", 1, map[string]interface{}{"ops": 1.0})}, func() {
+		replaced := seriesDoc("t1", 1, map[string]interface{}{"ops": 2.0})
+		if err := jsonStore.Insert(&replaced); err != nil {
+			t.Fatal(err)
+		}
+		doc, err := jsonStore.FindByTaskId("t1", "perf")
+		if err != nil || doc == nil {
+			t.Fatalf("expected a document, got %v (%v)", doc, err)
+		}
+		if !reflect.DeepEqual(doc.Data, map[string]interface{}{"ops": 2.0}) {
+			t.Errorf("expected the document to be replaced, got %v", doc.Data)
+		}
+		docs, err := jsonStore.FindByVersion("v1", "perf")
+		if err != nil || len(docs) != 1 {
+			t.Errorf("expected one document in the version, got %v (%v)", len(docs), err)
+		}
+
+		if doc, _ = jsonStore.FindByTaskId("t1", "other"); doc != nil {
+			t.Errorf("expected no document under another name, got %+v", doc)
+		}
+		if doc, _ = jsonStore.FindByTaskId("t2", "perf"); doc != nil {
+			t.Errorf("expected no document for another task, got %+v", doc)
+		}
+	})
+}
+
+func TestMemoryStoreFindHistory(t *testing.T) {
+	docs := []TaskJSON{}
+	for order := 5; order >= 1; order-- {
+		docs = append(docs, seriesDoc(fmt.Sprintf("t%v", order), order, nil))
+	}
+	patch := seriesDoc("patch", 3, nil)
+	patch.IsPatch = true
+	other := seriesDoc("other", 3, nil)
+	other.Variant = "windows"
+	docs = append(docs, patch, other)
+
+	withMemoryStore(t, docs, func() {
+		key := SeriesKey{ProjectId: "p", Variant: "linux", TaskName: "bench", Name: "perf"}
+		history, err := jsonStore.FindHistory(HistoryQuery{SeriesKey: key, Order: 3, Before: 2, After: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(orders(history), []int{2, 3, 4}) {
+			t.Errorf("expected orders 2 to 4, got %v", orders(history))
+		}
+
+		page, err := jsonStore.FindHistoryRange(HistoryRange{SeriesKey: key, FromOrder: 2, AfterOrder: 2, Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(orders(page), []int{3, 4}) {
+			t.Errorf("expected orders 3 and 4, got %v", orders(page))
+		}
+
+		doc, err := jsonStore.FindByRevision(key, "R4")
+		if err != nil || doc == nil || doc.TaskId != "t4" {
+			t.Errorf("expected the document at revision r4, got %+v (%v)", doc, err)
+		}
+	})
+}
+
+func TestMemoryStoreFindLatest(t *testing.T) {
+	patch := seriesDoc("patch", 9, nil)
+	patch.IsPatch = true
+	other := seriesDoc("other", 1, nil)
+	other.Variant = "windows"
+	docs := []TaskJSON{seriesDoc("t1", 1, nil), seriesDoc("t2", 2, nil), patch, other}
+
+	withMemoryStore(t, docs, func() {
+		latest, err := jsonStore.FindLatest("p", "perf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		taskIds := map[string]bool{}
+		for _, doc := range latest {
+			taskIds[doc.TaskId] = true
+		}
+		if !reflect.DeepEqual(taskIds, map[string]bool{"t2": true, "other": true}) {
+			t.Errorf("expected the latest mainline document on each variant, got %v", taskIds)
+		}
+
+		versionId, err := jsonStore.FindLatestVersionId("p", "perf")
+		if err != nil || versionId != "v9" {
+			t.Errorf("expected v9, got '%v' (%v)", versionId, err)
+		}
+		if versionId, _ = jsonStore.FindLatestVersionId("p", "other"); versionId != "" {
+			t.Errorf("expected no version for a name with no documents, got '%v'", versionId)
+		}
+	})
+}
@@ -0,0 +1,899 @@
+package evgjson
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/evergreen-ci/evergreen/db"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// mgoStore is the JSONStore that keeps documents in the "json" collection
+// of evergreen's database.
+type mgoStore struct{}
+
+// findOne runs a query that matches at most one document, turning a
+// missing document into a nil result. The document is limited to the
+// given paths into its data.
+func (s *mgoStore) findOne(q db.Q, fields []string) (*TaskJSON, error) {
+	jsonForTask := &TaskJSON{}
+	err := db.FindOneQ(collection, withFields(q, fields), jsonForTask)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = loadDataFile(jsonForTask, fields); err != nil {
+		return nil, err
+	}
+	return jsonForTask, nil
+}
+
+// findAll runs a query, limiting each document to the given paths into its data.
+func (s *mgoStore) findAll(q db.Q, fields []string) ([]TaskJSON, error) {
+	jsonForTasks := []TaskJSON{}
+	if err := db.FindAllQ(collection, withFields(q, fields), &jsonForTasks); err != nil {
+		return nil, err
+	}
+	for i := range jsonForTasks {
+		if err := loadDataFile(&jsonForTasks[i], fields); err != nil {
+			return nil, err
+		}
+	}
+	return jsonForTasks, nil
+}
+
+// iter runs a query through the session and passes each document, limited
+// to the given paths into its data, to each as it is read from the cursor
+// rather than reading them all first. It stops at the first error each
+// returns.
+func (s *mgoStore) iter(query bson.M, sort []string, limit int, fields []string, each func(*TaskJSON) error) error {
+	session, database, err := db.GetGlobalSessionFactory().GetSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	q := database.C(collection).Find(query)
+	if len(fields) > 0 {
+		selector := bson.M{}
+		for _, key := range projectionKeys(fields) {
+			selector[key] = 1
+		}
+		q = q.Select(selector)
+	}
+	if len(sort) > 0 {
+		q = q.Sort(sort...)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	it := q.Iter()
+	doc := TaskJSON{}
+	for it.Next(&doc) {
+		if err = loadDataFile(&doc, fields); err == nil {
+			err = each(&doc)
+		}
+		if err != nil {
+			it.Close()
+			return err
+		}
+		doc = TaskJSON{}
+	}
+	return it.Close()
+}
+
+// withFields limits a query to the given paths into each document's data.
+func withFields(q db.Q, fields []string) db.Q {
+	if len(fields) == 0 {
+		return q
+	}
+	return q.WithFields(projectionKeys(fields)...)
+}
+
+// notSuperseded is the condition for documents from a task's latest execution.
+var notSuperseded = bson.M{"$ne": true}
+
+func seriesQuery(key SeriesKey) bson.M {
+	return bson.M{
+		ProjectIdKey:  key.ProjectId,
+		VariantKey:    key.Variant,
+		TaskNameKey:   key.TaskName,
+		NameKey:       key.Name,
+		SupersededKey: notSuperseded,
+	}
+}
+
+// executionSelector matches the document for doc's task id, name and
+// execution. Documents stored before executions were recorded count as
+// the first execution.
+func executionSelector(doc *TaskJSON) bson.M {
+	selector := bson.M{TaskIdKey: doc.TaskId, NameKey: doc.Name, ExecutionKey: doc.Execution}
+	if doc.Execution == 0 {
+		selector[ExecutionKey] = bson.M{"$in": []interface{}{0, nil}}
+	}
+	return selector
+}
+
+// withExecution returns a copy of doc marked as superseded if a later
+// execution of its task already stored a document under its name.
+func withExecution(doc *TaskJSON) (*TaskJSON, error) {
+	later, err := db.Count(collection, bson.M{TaskIdKey: doc.TaskId, NameKey: doc.Name,
+		ExecutionKey: bson.M{"$gt": doc.Execution}})
+	if err != nil {
+		return nil, err
+	}
+	marked := *doc
+	marked.Superseded = later > 0
+	return &marked, nil
+}
+
+// supersede marks the documents that earlier executions of doc's task
+// stored under its name as superseded.
+func supersede(doc *TaskJSON) error {
+	if doc.Superseded {
+		return nil
+	}
+	_, err := db.UpdateAll(collection,
+		bson.M{TaskIdKey: doc.TaskId, NameKey: doc.Name, ExecutionKey: bson.M{"$not": bson.M{"$gte": doc.Execution}}},
+		bson.M{"$set": bson.M{SupersededKey: true}})
+	return err
+}
+
+func (s *mgoStore) Insert(doc *TaskJSON) error {
+	doc, err := withExecution(doc)
+	if err != nil {
+		return err
+	}
+	selector := executionSelector(doc)
+	// the data of the document being replaced may be in its own file
+	previous := &TaskJSON{}
+	err = db.FindOneQ(collection, db.Query(selector).WithFields(DataFileKey), previous)
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	doc.WriteId = bson.NewObjectId()
+	stored, err := chunkData(doc)
+	if err != nil {
+		return err
+	}
+	if _, err = db.Upsert(collection, selector, stored); err != nil {
+		return err
+	}
+	if previous.DataFile != "" {
+		if err = removeDataFiles([]string{previous.DataFile}); err != nil {
+			return err
+		}
+	}
+	return supersede(doc)
+}
+
+// metadataUpdate sets every key of a document other than its data, tags
+// and the task id and name it is found by.
+func metadataUpdate(doc *TaskJSON) bson.M {
+	return bson.M{
+		TaskNameKey:            doc.TaskName,
+		ProjectIdKey:           doc.ProjectId,
+		BuildIdKey:             doc.BuildId,
+		VariantKey:             doc.Variant,
+		VersionIdKey:           doc.VersionId,
+		CreateTimeKey:          doc.CreateTime,
+		IsPatchKey:             doc.IsPatch,
+		RevisionOrderNumberKey: doc.RevisionOrderNumber,
+		RevisionKey:            doc.Revision,
+		ExecutionKey:           doc.Execution,
+		SupersededKey:          doc.Superseded,
+		WriteIdKey:             doc.WriteId,
+	}
+}
+
+// maxUpdateAttempts is how many times update reads a document again after
+// it changed between being read and written.
+const maxUpdateAttempts = 5
+
+// update combines doc's data in a mode with the data stored for doc's
+// task id, name and execution, creating the document if it does not
+// exist. The update is built from the stored data, which is nil if there
+// is none, and is only written if the document hasn't changed since, so
+// concurrent updates can't lose each other's data; update tries again
+// with the newly stored data if it has. Data kept in GridFS can't be
+// updated in place, so documents whose data is there are refused with
+// errNotUpdatable. A document that the update would make too large is
+// stored in GridFS if chunking is on, and is otherwise refused with
+// errPayloadTooLarge.
+func (s *mgoStore) update(doc *TaskJSON, mode string, update func(doc *TaskJSON, existing interface{}) bson.M) error {
+	doc, err := withExecution(doc)
+	if err != nil {
+		return err
+	}
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		written, err := s.tryUpdate(doc, mode, update)
+		if err != nil {
+			return err
+		}
+		if written {
+			return supersede(doc)
+		}
+	}
+	return fmt.Errorf("the data stored for '%v' kept changing while it was being updated", doc.Name)
+}
+
+// tryUpdate makes one attempt at an update, returning whether it was
+// written. The write is conditional on the document's write id being the
+// one that was read, or, for a new document, on the unique index on its
+// task id, name and execution.
+func (s *mgoStore) tryUpdate(doc *TaskJSON, mode string, update func(doc *TaskJSON, existing interface{}) bson.M) (bool, error) {
+	selector := executionSelector(doc)
+	existing := &TaskJSON{}
+	err := db.FindOneQ(collection, db.Query(selector), existing)
+	if err != nil && err != mgo.ErrNotFound {
+		return false, err
+	}
+	found := err == nil
+
+	incoming := *doc
+	incoming.WriteId = bson.NewObjectId()
+	combined := incoming
+	var existingData interface{}
+	if found {
+		if existing.DataFile != "" {
+			return false, errNotUpdatable{doc.Name}
+		}
+		existingData = existing.Data
+		combined.Tags = existing.Tags
+	}
+	if combined.Data, err = combineData(mode, existingData, doc.Data); err != nil {
+		return false, err
+	}
+	raw, err := bson.Marshal(&combined)
+	if err != nil {
+		return false, err
+	}
+
+	// a new document is inserted whole, as is one too large to update in place
+	var change interface{} = update(&incoming, existingData)
+	if !found {
+		change = &combined
+	}
+	dataFile := ""
+	limit := maxDocumentBytes
+	if settings.ChunkThresholdBytes > 0 && settings.ChunkThresholdBytes < limit {
+		limit = settings.ChunkThresholdBytes
+	}
+	if len(raw) > limit {
+		if settings.ChunkThresholdBytes <= 0 {
+			return false, errPayloadTooLarge{maxDocumentBytes}
+		}
+		stored, err := chunkData(&combined)
+		if err != nil {
+			return false, err
+		}
+		change = stored
+		dataFile = stored.DataFile
+	}
+
+	if found {
+		condition := bson.M{WriteIdKey: existing.WriteId}
+		if existing.WriteId == "" {
+			condition[WriteIdKey] = bson.M{"$exists": false}
+		}
+		for k, v := range selector {
+			condition[k] = v
+		}
+		err = db.Update(collection, condition, change)
+	} else {
+		err = db.Insert(collection, change)
+	}
+	if err == nil {
+		return true, nil
+	}
+	if dataFile != "" {
+		if removeErr := removeDataFiles([]string{dataFile}); removeErr != nil {
+			return false, removeErr
+		}
+	}
+	// the document changed, or was created, after it was read
+	if err == mgo.ErrNotFound || mgo.IsDup(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// mergeUpdates returns the fields to set to merge a value into the one
+// already at a path, descending into objects the way mergeData does: an
+// object merged into an object sets only its own fields, so an empty one
+// changes nothing, and any other value replaces what is there.
+func mergeUpdates(path string, value, existing interface{}, updates bson.M) {
+	m, ok := asMap(value)
+	existingMap, existingIsMap := asMap(existing)
+	if !ok || !existingIsMap {
+		updates[path] = value
+		return
+	}
+	for k, v := range m {
+		mergeUpdates(path+"."+k, v, existingMap[k], updates)
+	}
+}
+
+func (s *mgoStore) Merge(doc *TaskJSON) error {
+	if _, ok := asMap(doc.Data); !ok {
+		return fmt.Errorf("only an object can be merged")
+	}
+	return s.update(doc, MergeMode, func(doc *TaskJSON, existing interface{}) bson.M {
+		set := metadataUpdate(doc)
+		mergeUpdates(DataKey, doc.Data, existing, set)
+		return bson.M{"$set": set}
+	})
+}
+
+func (s *mgoStore) Append(doc *TaskJSON) error {
+	return s.update(doc, AppendMode, func(doc *TaskJSON, existing interface{}) bson.M {
+		return bson.M{
+			"$set":  metadataUpdate(doc),
+			"$push": bson.M{DataKey: bson.M{"$each": appendElems(doc.Data)}},
+		}
+	})
+}
+
+func (s *mgoStore) FindByTaskId(taskId, name string, fields ...string) (*TaskJSON, error) {
+	return s.findOne(db.Query(bson.M{TaskIdKey: taskId, NameKey: name, SupersededKey: notSuperseded}), fields)
+}
+
+func (s *mgoStore) FindByTaskExecution(taskId, name string, execution int, fields ...string) (*TaskJSON, error) {
+	return s.findOne(db.Query(executionSelector(&TaskJSON{TaskId: taskId, Name: name, Execution: execution})), fields)
+}
+
+func (s *mgoStore) FindByBuild(versionId, buildId, taskName, name string, fields ...string) (*TaskJSON, error) {
+	return s.findOne(db.Query(bson.M{VersionIdKey: versionId, BuildIdKey: buildId, NameKey: name,
+		TaskNameKey: taskName, SupersededKey: notSuperseded}), fields)
+}
+
+func versionQuery(versionId, name string) bson.M {
+	return bson.M{VersionIdKey: versionId, NameKey: name, SupersededKey: notSuperseded}
+}
+
+func (s *mgoStore) FindByVersion(versionId, name string, fields ...string) ([]TaskJSON, error) {
+	return s.findAll(db.Query(versionQuery(versionId, name)), fields)
+}
+
+func (s *mgoStore) IterByVersion(versionId, name string, fields []string, each func(*TaskJSON) error) error {
+	return s.iter(versionQuery(versionId, name), nil, 0, fields, each)
+}
+
+func (s *mgoStore) FindLatest(projectId, name string, fields ...string) ([]TaskJSON, error) {
+	latest := []struct {
+		TaskId string `bson:"task_id"`
+	}{}
+	err := db.Aggregate(collection, []bson.M{
+		{"$match": bson.M{ProjectIdKey: projectId, NameKey: name, IsPatchKey: false, SupersededKey: notSuperseded}},
+		{"$sort": bson.M{RevisionOrderNumberKey: -1}},
+		{"$group": bson.M{
+			"_id":     bson.M{VariantKey: "$" + VariantKey, TaskNameKey: "$" + TaskNameKey},
+			TaskIdKey: bson.M{"$first": "$" + TaskIdKey},
+		}},
+	}, &latest)
+	if err != nil {
+		return nil, err
+	}
+	taskIds := make([]string, 0, len(latest))
+	for _, doc := range latest {
+		taskIds = append(taskIds, doc.TaskId)
+	}
+	return s.findAll(db.Query(bson.M{TaskIdKey: bson.M{"$in": taskIds}, NameKey: name,
+		SupersededKey: notSuperseded}), fields)
+}
+
+func (s *mgoStore) FindLatestVersionId(projectId, name string) (string, error) {
+	jsonTask, err := s.findOne(db.Query(bson.M{NameKey: name, ProjectIdKey: projectId, SupersededKey: notSuperseded}).
+		Sort([]string{"-" + RevisionOrderNumberKey}).WithFields(VersionIdKey), nil)
+	if err != nil || jsonTask == nil {
+		return "", err
+	}
+	return jsonTask.VersionId, nil
+}
+
+func (s *mgoStore) FindByRevision(key SeriesKey, revision string, fields ...string) (*TaskJSON, error) {
+	q := seriesQuery(key)
+	q[RevisionKey] = bson.RegEx{"^" + regexp.QuoteMeta(revision), "i"}
+	q[IsPatchKey] = false
+	return s.findOne(db.Query(q), fields)
+}
+
+func (s *mgoStore) FindHistory(q HistoryQuery) ([]TaskJSON, error) {
+	history := []TaskJSON{}
+	if q.Before > 0 {
+		before, err := s.findHistoryBefore(q)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, before...)
+	}
+	if q.After > 0 {
+		after, err := s.findHistoryAfter(q)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, after...)
+	}
+	return history, nil
+}
+
+func historyBeforeQuery(q HistoryQuery) bson.M {
+	query := seriesQuery(q.SeriesKey)
+	query[RevisionOrderNumberKey] = bson.M{"$lte": q.Order}
+	query[IsPatchKey] = false
+	return query
+}
+
+func historyAfterQuery(q HistoryQuery) bson.M {
+	query := seriesQuery(q.SeriesKey)
+	query[RevisionOrderNumberKey] = bson.M{"$gt": q.Order}
+	query[IsPatchKey] = false
+	return query
+}
+
+func (s *mgoStore) findHistoryBefore(q HistoryQuery) ([]TaskJSON, error) {
+	before, err := s.findAll(db.Query(historyBeforeQuery(q)).
+		Sort([]string{"-" + RevisionOrderNumberKey}).Limit(q.Before), q.Fields)
+	if err != nil {
+		return nil, err
+	}
+	//reverse order of "before" because we had to sort it backwards to apply the limit correctly:
+	for i, j := 0, len(before)-1; i < j; i, j = i+1, j-1 {
+		before[i], before[j] = before[j], before[i]
+	}
+	return before, nil
+}
+
+func (s *mgoStore) findHistoryAfter(q HistoryQuery) ([]TaskJSON, error) {
+	return s.findAll(db.Query(historyAfterQuery(q)).
+		Sort([]string{RevisionOrderNumberKey}).Limit(q.After), q.Fields)
+}
+
+func (s *mgoStore) IterHistory(q HistoryQuery, each func(*TaskJSON) error) error {
+	if q.Before > 0 {
+		// the window starts at the Before'th document back from its
+		// center, which is found first so the rest can be read in order
+		beforeQuery := historyBeforeQuery(q)
+		first := []TaskJSON{}
+		err := db.FindAllQ(collection, db.Query(beforeQuery).WithFields(RevisionOrderNumberKey).
+			Sort([]string{"-" + RevisionOrderNumberKey}).Skip(q.Before-1).Limit(1), &first)
+		if err != nil {
+			return err
+		}
+		if len(first) > 0 {
+			beforeQuery[RevisionOrderNumberKey] = bson.M{"$gte": first[0].RevisionOrderNumber, "$lte": q.Order}
+		}
+		err = s.iter(beforeQuery, []string{RevisionOrderNumberKey}, 0, q.Fields, each)
+		if err != nil {
+			return err
+		}
+	}
+	if q.After > 0 {
+		return s.iter(historyAfterQuery(q), []string{RevisionOrderNumberKey}, q.After, q.Fields, each)
+	}
+	return nil
+}
+
+func historyRangeQuery(q HistoryRange) bson.M {
+	query := seriesQuery(q.SeriesKey)
+	query[IsPatchKey] = false
+	order := bson.M{}
+	if q.FromOrder > 0 {
+		order["$gte"] = q.FromOrder
+	}
+	if q.ToOrder > 0 {
+		order["$lte"] = q.ToOrder
+	}
+	if q.AfterOrder > 0 {
+		order["$gt"] = q.AfterOrder
+	}
+	if len(order) > 0 {
+		query[RevisionOrderNumberKey] = order
+	}
+	created := bson.M{}
+	if !q.Start.IsZero() {
+		created["$gte"] = q.Start
+	}
+	if !q.End.IsZero() {
+		created["$lte"] = q.End
+	}
+	if len(created) > 0 {
+		query[CreateTimeKey] = created
+	}
+	return query
+}
+
+func (s *mgoStore) FindHistoryRange(q HistoryRange) ([]TaskJSON, error) {
+	return s.findAll(db.Query(historyRangeQuery(q)).
+		Sort([]string{RevisionOrderNumberKey}).Limit(q.Limit), q.Fields)
+}
+
+func (s *mgoStore) IterHistoryRange(q HistoryRange, each func(*TaskJSON) error) error {
+	return s.iter(historyRangeQuery(q), []string{RevisionOrderNumberKey}, q.Limit, q.Fields, each)
+}
+
+func (s *mgoStore) FindSeries(q SeriesQuery) ([]Series, error) {
+	values := make([]interface{}, 0, len(q.Paths))
+	for _, path := range q.Paths {
+		values = append(values, aggregationPath(path))
+	}
+	rows := []seriesRow{}
+	err := db.Aggregate(collection, []bson.M{
+		{"$match": bson.M{
+			ProjectIdKey:  q.ProjectId,
+			VariantKey:    bson.M{"$in": q.Variants},
+			TaskNameKey:   q.TaskName,
+			NameKey:       q.Name,
+			IsPatchKey:    false,
+			SupersededKey: notSuperseded,
+		}},
+		{"$sort": bson.M{RevisionOrderNumberKey: 1}},
+		{"$project": bson.M{
+			"_id":                  0,
+			VariantKey:             1,
+			RevisionKey:            1,
+			RevisionOrderNumberKey: 1,
+			CreateTimeKey:          1,
+			DataFileKey:            1,
+			"values":               values,
+		}},
+	}, &rows)
+	if err != nil {
+		return nil, err
+	}
+	// the data of chunked documents is not in the collection to aggregate
+	for i := range rows {
+		if rows[i].DataFile == "" {
+			continue
+		}
+		doc := &TaskJSON{DataFile: rows[i].DataFile}
+		if err = loadDataFile(doc, nil); err != nil {
+			return nil, err
+		}
+		rows[i].Values = rows[i].Values[:0]
+		for _, path := range q.Paths {
+			value, _ := lookupPath(doc.Data, path)
+			rows[i].Values = append(rows[i].Values, value)
+		}
+	}
+	return groupSeries(q, rows), nil
+}
+
+func taggedQuery(key SeriesKey) bson.M {
+	q := seriesQuery(key)
+	q[TagsKey+".0"] = bson.M{"$exists": true}
+	return q
+}
+
+func (s *mgoStore) FindTagged(key SeriesKey, fields ...string) ([]TaskJSON, error) {
+	return s.findAll(db.Query(taggedQuery(key)), fields)
+}
+
+func (s *mgoStore) IterTagged(key SeriesKey, fields []string, each func(*TaskJSON) error) error {
+	return s.iter(taggedQuery(key), nil, 0, fields, each)
+}
+
+func (s *mgoStore) FindByTag(key SeriesKey, tag string, fields ...string) (*TaskJSON, error) {
+	q := seriesQuery(key)
+	q[TagsKey+"."+TagInfoNameKey] = tag
+	return s.findOne(db.Query(q), fields)
+}
+
+func (s *mgoStore) AddTag(versionId, name string, tag TagInfo) error {
+	tagNameKey := TagsKey + "." + TagInfoNameKey
+	// replace the tag where it is already set, then add it everywhere else
+	_, err := db.UpdateAll(collection,
+		bson.M{VersionIdKey: versionId, NameKey: name, tagNameKey: tag.Name},
+		bson.M{"$set": bson.M{TagsKey + ".$": tag}})
+	if err != nil {
+		return err
+	}
+	_, err = db.UpdateAll(collection,
+		bson.M{VersionIdKey: versionId, NameKey: name, tagNameKey: bson.M{"$ne": tag.Name}},
+		bson.M{"$push": bson.M{TagsKey: tag}})
+	return err
+}
+
+func (s *mgoStore) RemoveTag(versionId, name, tag string) error {
+	_, err := db.UpdateAll(collection,
+		bson.M{VersionIdKey: versionId, NameKey: name},
+		bson.M{"$pull": bson.M{TagsKey: bson.M{TagInfoNameKey: tag}}})
+	return err
+}
+
+func (s *mgoStore) FindTaggedVersionIds(projectId, name, tag string) ([]string, error) {
+	versions := []struct {
+		VersionId string `bson:"_id"`
+	}{}
+	err := db.Aggregate(collection, []bson.M{
+		{"$match": bson.M{ProjectIdKey: projectId, NameKey: name, TagsKey + "." + TagInfoNameKey: tag,
+			SupersededKey: notSuperseded}},
+		{"$group": bson.M{"_id": "$" + VersionIdKey}},
+	}, &versions)
+	if err != nil {
+		return nil, err
+	}
+	versionIds := make([]string, 0, len(versions))
+	for _, v := range versions {
+		versionIds = append(versionIds, v.VersionId)
+	}
+	return versionIds, nil
+}
+
+func (s *mgoStore) ListTags(projectId string) ([]TagCount, error) {
+	tags := []TagCount{}
+	err := db.Aggregate(collection, []bson.M{
+		{"$match": bson.M{ProjectIdKey: projectId, TagsKey + ".0": bson.M{"$exists": true},
+			SupersededKey: notSuperseded}},
+		{"$project": bson.M{TagsKey: 1}},
+		{"$unwind": "$" + TagsKey},
+		{"$group": bson.M{"_id": "$" + TagsKey + "." + TagInfoNameKey, "count": bson.M{"$sum": 1}}},
+		{"$sort": bson.M{"_id": 1}},
+	}, &tags)
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// legacyTagKey is the field that held a document's only tag before tags
+// were a set.
+const legacyTagKey = "tag"
+
+const (
+	// migrationCollection records the migrations that have finished, so
+	// that they are not run again on every start.
+	migrationCollection = "json_migrations"
+	// tagsMigration is the id of the record of MigrateTags finishing.
+	tagsMigration = "tags"
+)
+
+func (s *mgoStore) MigrateTags() error {
+	done, err := db.Count(migrationCollection, bson.M{"_id": tagsMigration})
+	if err != nil || done > 0 {
+		return err
+	}
+
+	// a single tag in its own field
+	legacy := []struct {
+		Tag string `bson:"_id"`
+	}{}
+	err = db.Aggregate(collection, []bson.M{
+		{"$match": bson.M{legacyTagKey: bson.M{"$exists": true}}},
+		{"$group": bson.M{"_id": "$" + legacyTagKey}},
+	}, &legacy)
+	if err != nil {
+		return err
+	}
+	for _, t := range legacy {
+		update := bson.M{"$unset": bson.M{legacyTagKey: 1}}
+		if t.Tag != "" {
+			update["$push"] = bson.M{TagsKey: TagInfo{Name: t.Tag}}
+		}
+		if _, err = db.UpdateAll(collection, bson.M{legacyTagKey: t.Tag}, update); err != nil {
+			return err
+		}
+	}
+
+	// bare names in the set of tags
+	bare := []struct {
+		Tag string `bson:"_id"`
+	}{}
+	err = db.Aggregate(collection, []bson.M{
+		{"$match": bson.M{TagsKey: bson.M{"$type": "string"}}},
+		{"$project": bson.M{TagsKey: 1}},
+		{"$unwind": "$" + TagsKey},
+		{"$match": bson.M{TagsKey: bson.M{"$type": "string"}}},
+		{"$group": bson.M{"_id": "$" + TagsKey}},
+	}, &bare)
+	if err != nil {
+		return err
+	}
+	for _, t := range bare {
+		_, err = db.UpdateAll(collection,
+			bson.M{TagsKey: t.Tag, TagsKey + "." + TagInfoNameKey: bson.M{"$ne": t.Tag}},
+			bson.M{"$push": bson.M{TagsKey: TagInfo{Name: t.Tag}}})
+		if err != nil {
+			return err
+		}
+		if _, err = db.UpdateAll(collection, bson.M{TagsKey: t.Tag}, bson.M{"$pull": bson.M{TagsKey: t.Tag}}); err != nil {
+			return err
+		}
+	}
+	_, err = db.Upsert(migrationCollection, bson.M{"_id": tagsMigration},
+		bson.M{"$set": bson.M{"finished": time.Now()}})
+	return err
+}
+
+func (s *mgoStore) RecordTagEvent(event *TagEvent) error {
+	return db.Insert(tagHistoryCollection, event)
+}
+
+func (s *mgoStore) FindTagHistory(q TagHistoryQuery) ([]TagEvent, error) {
+	query := bson.M{TagEventProjectIdKey: q.ProjectId}
+	if q.Name != "" {
+		query[TagEventNameKey] = q.Name
+	}
+	if q.Tag != "" {
+		query[TagEventTagKey] = q.Tag
+	}
+	events := []TagEvent{}
+	err := db.FindAllQ(tagHistoryCollection, db.Query(query).
+		Sort([]string{"-" + TagEventCreateTimeKey}).Limit(q.Limit), &events)
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func baselineQuery(key SeriesKey) bson.M {
+	return bson.M{
+		BaselineProjectIdKey: key.ProjectId,
+		BaselineVariantKey:   key.Variant,
+		BaselineTaskNameKey:  key.TaskName,
+		BaselineNameKey:      key.Name,
+	}
+}
+
+func (s *mgoStore) SetBaseline(baseline *Baseline) error {
+	_, err := db.Upsert(baselineCollection, baselineQuery(SeriesKey{
+		ProjectId: baseline.ProjectId,
+		Variant:   baseline.Variant,
+		TaskName:  baseline.TaskName,
+		Name:      baseline.Name,
+	}), baseline)
+	return err
+}
+
+func (s *mgoStore) FindBaseline(key SeriesKey) (*Baseline, error) {
+	baseline := &Baseline{}
+	err := db.FindOneQ(baselineCollection, db.Query(baselineQuery(key)), baseline)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return baseline, nil
+}
+
+func (s *mgoStore) RemoveBaseline(key SeriesKey) error {
+	err := db.Remove(baselineCollection, baselineQuery(key))
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (s *mgoStore) FindBaselines(projectId string) ([]Baseline, error) {
+	baselines := []Baseline{}
+	err := db.FindAllQ(baselineCollection, db.Query(bson.M{BaselineProjectIdKey: projectId}), &baselines)
+	if err != nil {
+		return nil, err
+	}
+	return baselines, nil
+}
+
+func pruneQuery(q PruneQuery) bson.M {
+	query := bson.M{
+		ProjectIdKey:   q.ProjectId,
+		IsPatchKey:     q.IsPatch,
+		CreateTimeKey:  bson.M{"$lt": q.CreatedBefore},
+		TagsKey + ".0": bson.M{"$exists": false},
+	}
+	if len(q.Baselines) > 0 {
+		keep := make([]bson.M, 0, len(q.Baselines))
+		for _, baseline := range q.Baselines {
+			keep = append(keep, bson.M{TaskIdKey: baseline.TaskId, NameKey: baseline.Name})
+		}
+		query["$nor"] = keep
+	}
+	return query
+}
+
+func (s *mgoStore) FindPrunable(q PruneQuery) ([]TaskJSON, error) {
+	docs := []TaskJSON{}
+	err := db.FindAllQ(collection, db.Query(pruneQuery(q)).WithFields(metadataKeys...).
+		Sort([]string{CreateTimeKey}), &docs)
+	if err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+func (s *mgoStore) RemovePrunable(q PruneQuery) (int, error) {
+	query := pruneQuery(q)
+	chunked := []TaskJSON{}
+	chunkedQuery := bson.M{DataFileKey: bson.M{"$exists": true}}
+	for k, v := range query {
+		chunkedQuery[k] = v
+	}
+	err := db.FindAllQ(collection, db.Query(chunkedQuery).WithFields(DataFileKey), &chunked)
+	if err != nil {
+		return 0, err
+	}
+
+	// go through the session directly, since db.RemoveAll does not say how
+	// many documents it removed
+	session, database, err := db.GetGlobalSessionFactory().GetSession()
+	if err != nil {
+		return 0, err
+	}
+	defer session.Close()
+	info, err := database.C(collection).RemoveAll(query)
+	if err != nil {
+		return 0, err
+	}
+
+	files := make([]string, 0, len(chunked))
+	for _, doc := range chunked {
+		files = append(files, doc.DataFile)
+	}
+	if err = removeDataFiles(files); err != nil {
+		return 0, err
+	}
+	return info.Removed, nil
+}
+
+// namespaceNotFound is the error code the database returns for a
+// collection that does not exist.
+const namespaceNotFound = 26
+
+func (s *mgoStore) ListIndexes(coll string) ([][]string, error) {
+	session, database, err := db.GetGlobalSessionFactory().GetSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	indexes, err := database.C(coll).Indexes()
+	if queryErr, ok := err.(*mgo.QueryError); ok && queryErr.Code == namespaceNotFound {
+		// the collection has not been created yet
+		return [][]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	keys := make([][]string, 0, len(indexes))
+	for _, index := range indexes {
+		keys = append(keys, index.Key)
+	}
+	return keys, nil
+}
+
+func (s *mgoStore) EnsureIndex(index IndexSpec) error {
+	return db.EnsureIndex(index.Collection, mgo.Index{
+		Key:        index.Key,
+		Unique:     index.Unique,
+		Background: true,
+	})
+}
+
+func (s *mgoStore) SetSchema(schema *Schema) error {
+	_, err := db.Upsert(schemaCollection,
+		bson.M{SchemaProjectIdKey: schema.ProjectId, SchemaNameKey: schema.Name}, schema)
+	return err
+}
+
+func (s *mgoStore) FindSchema(projectId, name string) (*Schema, error) {
+	schema := &Schema{}
+	err := db.FindOneQ(schemaCollection,
+		db.Query(bson.M{SchemaProjectIdKey: projectId, SchemaNameKey: name}), schema)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+func (s *mgoStore) RemoveSchema(projectId, name string) error {
+	err := db.Remove(schemaCollection, bson.M{SchemaProjectIdKey: projectId, SchemaNameKey: name})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package evgjson
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/10gen-labs/slogger/v1"
+	"github.com/evergreen-ci/evergreen"
+)
+
+// ndjsonContentType is the media type of newline delimited json, in which
+// a list of documents is sent one document per line.
+const ndjsonContentType = "application/x-ndjson"
+
+// docIter passes a list of documents to each, one at a time as they are
+// read, stopping at the first error each returns.
+type docIter func(each func(*TaskJSON) error) error
+
+// wantsNDJSON returns whether a request for a list of documents asks, with
+// its Accept header, for them to be streamed as newline delimited json. A
+// "format" parameter takes precedence.
+func wantsNDJSON(r *http.Request) bool {
+	if r.FormValue("format") != "" {
+		return false
+	}
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		if strings.TrimSpace(strings.Split(accepted, ";")[0]) == ndjsonContentType {
+			return true
+		}
+	}
+	return false
+}
+
+// streamDocs sends each document iter passes to its callback as a line of
+// json as soon as it is read, so a long list is never held in memory. If
+// iter passes no documents, emptyStatus is sent instead. Once a document
+// has been sent the status can no longer change, so an error after that
+// cuts the response short and is only logged.
+func streamDocs(w http.ResponseWriter, emptyStatus int, iter docIter) {
+	w.Header().Set("Content-Type", ndjsonContentType)
+	sent := 0
+	encoder := json.NewEncoder(w)
+	err := iter(func(doc *TaskJSON) error {
+		if sent == 0 {
+			w.WriteHeader(http.StatusOK)
+		}
+		sent++
+		return encoder.Encode(doc)
+	})
+	switch {
+	case err != nil && sent == 0:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	case err != nil:
+		evergreen.Logger.Logf(slogger.ERROR, "Error streaming documents after sending %v: %v", sent, err)
+	case sent == 0 && emptyStatus != http.StatusOK:
+		http.Error(w, "{}", emptyStatus)
+	}
+}
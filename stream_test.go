@@ -0,0 +1,137 @@
+package evgjson
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/evergreen-ci/evergreen/plugin"
+)
+
+// readLines decodes each line of newline delimited json in body as a
+// document, failing the test for a line that isn't one.
+func readLines(t *testing.T, body string) []TaskJSON {
+	docs := []TaskJSON{}
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		doc := TaskJSON{}
+		if err := json.Unmarshal(scanner.Bytes(), &doc); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+func TestWantsNDJSON(t *testing.T) {
+	cases := []struct {
+		query, accept string
+		expected      bool
+	}{
+		{"", "", false},
+		{"", "application/json", false},
+		{"", ndjsonContentType, true},
+		{"", "application/json, application/x-ndjson;q=0.9", true},
+		// a format takes precedence
+		{"?format=csv", ndjsonContentType, false},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/version/v1/perf/"+c.query, nil)
+		r.Header.Set("Accept", c.accept)
+		if wants := wantsNDJSON(r); wants != c.expected {
+			t.Errorf("'%v'%v: expected %v, got %v", c.accept, c.query, c.expected, wants)
+		}
+	}
+}
+
+func TestStreamDocs(t *testing.T) {
+	docs := versionDocs()
+	iter := func(err error, n int) docIter {
+		return func(each func(*TaskJSON) error) error {
+			for i := 0; i < n; i++ {
+				if err := each(&docs[i]); err != nil {
+					return err
+				}
+			}
+			return err
+		}
+	}
+
+	w := httptest.NewRecorder()
+	streamDocs(w, http.StatusNotFound, iter(nil, 2))
+	if w.Code != http.StatusOK || len(readLines(t, w.Body.String())) != 2 {
+		t.Errorf("expected two lines, got %v: %v", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	if streamDocs(w, http.StatusNotFound, iter(nil, 0)); w.Code != http.StatusNotFound {
+		t.Errorf("expected the empty status, got %v", w.Code)
+	}
+	w = httptest.NewRecorder()
+	if streamDocs(w, http.StatusOK, iter(nil, 0)); w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("expected an empty list, got %v: %v", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	if streamDocs(w, http.StatusOK, iter(errors.New("lost"), 0)); w.Code != http.StatusInternalServerError {
+		t.Errorf("expected an error before anything is sent to be a 500, got %v", w.Code)
+	}
+	// once a document is sent, an error can only cut the list short
+	w = httptest.NewRecorder()
+	streamDocs(w, http.StatusOK, iter(errors.New("lost"), 1))
+	if w.Code != http.StatusOK || len(readLines(t, w.Body.String())) != 1 {
+		t.Errorf("expected one line, got %v: %v", w.Code, w.Body.String())
+	}
+}
+
+func TestStreamRoutes(t *testing.T) {
+	docs := versionDocs()
+	docs[1].Tags = []TagInfo{{Name: "good"}}
+	docs = append(docs, seriesDoc("t2", 2, map[string]interface{}{"ops": 110.0}))
+
+	withMemoryStore(t, docs, func() {
+		w := serveGet("/version/v1/perf/", ndjsonContentType)
+		if w.Header().Get("Content-Type") != ndjsonContentType {
+			t.Fatalf("expected ndjson, got %v", w.Header())
+		}
+		if lines := readLines(t, w.Body.String()); len(lines) != 2 {
+			t.Errorf("expected a line for each document in the version, got %v", lines)
+		}
+		if w = serveGet("/version/v2/perf/", ndjsonContentType); w.Code != http.StatusOK {
+			t.Errorf("expected the other version to be streamed, got %v", w.Code)
+		}
+		if w = serveGet("/version/v9/perf/", ndjsonContentType); w.Code != http.StatusNotFound {
+			t.Errorf("missing version: expected 404, got %v", w.Code)
+		}
+
+		r := httptest.NewRequest("GET", "/tags/bench/perf", nil)
+		r.Header.Set("Accept", ndjsonContentType)
+		windows := seriesTask("w2", 2)
+		windows.BuildVariant = "windows"
+		plugin.SetTask(r, windows)
+		w = serveRoute("/tags/{task_name}/{name}", getTaskByTag, r)
+		if lines := readLines(t, w.Body.String()); len(lines) != 1 || lines[0].TaskId != "w1" {
+			t.Errorf("expected the tagged document, got %v", w.Body.String())
+		}
+
+		r = newRequest("GET", "/history/perf?limit=2", nil)
+		r.Header.Set("Accept", ndjsonContentType)
+		w = serveRoute("/history/{name}", forTask(seriesTask("t2", 2), getTaskHistory), r)
+		lines := readLines(t, w.Body.String())
+		if !reflect.DeepEqual(orders(lines), []int{1, 2}) {
+			t.Errorf("expected orders 1 and 2, got %v", orders(lines))
+		}
+		if trailer := w.Result().Trailer.Get(nextCursorHeader); trailer != "" {
+			t.Errorf("expected no cursor after the last page, got %v", trailer)
+		}
+		r = newRequest("GET", "/history/perf?limit=1", nil)
+		r.Header.Set("Accept", ndjsonContentType)
+		w = serveRoute("/history/{name}", forTask(seriesTask("t2", 2), getTaskHistory), r)
+		if trailer := w.Result().Trailer.Get(nextCursorHeader); trailer != "1" {
+			t.Errorf("expected the cursor in a trailer, got '%v'", trailer)
+		}
+	})
+}
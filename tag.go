@@ -1,31 +1,84 @@
 package evgjson
 
 import (
+	"fmt"
 	"net/http"
+	"time"
 
-	"github.com/evergreen-ci/evergreen/db"
+	"github.com/evergreen-ci/evergreen/db/bsonutil"
 	"github.com/evergreen-ci/evergreen/model/task"
 	"github.com/evergreen-ci/evergreen/plugin"
 	"github.com/evergreen-ci/evergreen/util"
 	"github.com/gorilla/mux"
-	"gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
 )
 
-// getTags finds TaskJSONs that have tags
+const (
+	// Actions recorded in the tag history.
+	TagAdded   = "add"
+	TagRemoved = "remove"
+
+	defaultTagHistoryLimit = 100
+)
+
+// TagInfo is a tag on a document, with who set it, when and why.
+type TagInfo struct {
+	Name       string    `bson:"name" json:"name"`
+	Author     string    `bson:"author" json:"author"`
+	CreateTime time.Time `bson:"create_time" json:"create_time"`
+	Note       string    `bson:"note,omitempty" json:"note,omitempty"`
+}
+
+// TagEvent records a tag being added to or removed from the documents
+// stored under a name in a version.
+type TagEvent struct {
+	ProjectId  string    `bson:"project_id" json:"project_id"`
+	VersionId  string    `bson:"version_id" json:"version_id"`
+	Name       string    `bson:"name" json:"name"`
+	Tag        string    `bson:"tag" json:"tag"`
+	Action     string    `bson:"action" json:"action"`
+	Author     string    `bson:"author" json:"author"`
+	Note       string    `bson:"note,omitempty" json:"note,omitempty"`
+	CreateTime time.Time `bson:"create_time" json:"create_time"`
+}
+
+var (
+	// BSON fields for the TagInfo struct
+	TagInfoNameKey = bsonutil.MustHaveTag(TagInfo{}, "Name")
+
+	// BSON fields for the TagEvent struct
+	TagEventProjectIdKey  = bsonutil.MustHaveTag(TagEvent{}, "ProjectId")
+	TagEventNameKey       = bsonutil.MustHaveTag(TagEvent{}, "Name")
+	TagEventTagKey        = bsonutil.MustHaveTag(TagEvent{}, "Tag")
+	TagEventCreateTimeKey = bsonutil.MustHaveTag(TagEvent{}, "CreateTime")
+)
+
+// TagCount is a tag used in a project and the number of documents carrying it.
+type TagCount struct {
+	Tag   string `bson:"_id" json:"tag"`
+	Count int    `bson:"count" json:"count"`
+}
+
+// TagHistoryQuery selects the tag events of a project, newest first.
+type TagHistoryQuery struct {
+	ProjectId string
+	// Name and Tag limit the events to one document name or tag if set.
+	Name  string
+	Tag   string
+	Limit int
+}
+
+// getTags lists the tags used in a task's project, with counts
 func getTags(w http.ResponseWriter, r *http.Request) {
 	t, err := task.FindOne(task.ById(mux.Vars(r)["task_id"]))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	tags := []struct {
-		Tag string `bson:"_id" json:"tag"`
-	}{}
-	err = db.Aggregate(collection, []bson.M{
-		{"$match": bson.M{ProjectIdKey: t.Project, TagKey: bson.M{"$exists": true, "$ne": ""}}},
-		{"$project": bson.M{TagKey: 1}}, bson.M{"$group": bson.M{"_id": "$tag"}},
-	}, &tags)
+	if t == nil {
+		http.Error(w, "{}", http.StatusNotFound)
+		return
+	}
+	tags, err := jsonStore.ListTags(t.Project)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -33,7 +86,16 @@ func getTags(w http.ResponseWriter, r *http.Request) {
 	plugin.WriteJSON(w, http.StatusOK, tags)
 }
 
-// handleTaskTags will update the TaskJSON's tags depending on the request.
+// handleTaskTag will update the TaskJSON's tags depending on the request.
+// A POST adds the tag in its body to the set of tags. A DELETE removes the
+// tag given by the "tag" parameter, or every tag if there is none. Every
+// change is recorded in the tag history along with the requesting user and
+// an optional note.
+//
+// Tags matching a project's protected patterns cannot be removed, or moved
+// by adding them to a version when another version already has them,
+// unless the "force" parameter is set by an admin. Adding a protected tag
+// to a version that already has it changes nothing.
 func handleTaskTag(w http.ResponseWriter, r *http.Request) {
 	t, err := task.FindOne(task.ById(mux.Vars(r)["task_id"]))
 	if err != nil {
@@ -44,19 +106,47 @@ func handleTaskTag(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "{}", http.StatusNotFound)
 		return
 	}
+	tagTask(t, w, r)
+}
+
+// tagTask changes the tags on the documents stored under a name in a task's version.
+func tagTask(t *task.Task, w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	author := ""
+	if u := plugin.GetUser(r); u != nil {
+		author = u.Username()
+	}
+
 	if r.Method == "DELETE" {
-		if _, err = db.UpdateAll(collection,
-			bson.M{VersionIdKey: t.Version, NameKey: mux.Vars(r)["name"]},
-			bson.M{"$unset": bson.M{TagKey: 1}}); err != nil {
+		tags, err := versionTags(t.Version, name)
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		// only a tag the version has is removed, or checked for protection
+		if tag := r.FormValue("tag"); tag != "" {
+			tags = filterTags(tags, tag)
+		}
+		for _, tag := range tags {
+			if code, err := checkProtectedTag(r, t.Project, tag, author); err != nil {
+				http.Error(w, err.Error(), code)
+				return
+			}
+		}
+		for _, tag := range tags {
+			if err = removeTag(t.Project, t.Version, name, tag, author, r.FormValue("note")); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
 		plugin.WriteJSON(w, http.StatusOK, "")
+		return
 	}
 	inTag := struct {
-		Tag string `json:"tag"`
+		Tag  string `json:"tag"`
+		Note string `json:"note"`
 	}{}
-	err = util.ReadJSONInto(r.Body, &inTag)
+	err := util.ReadJSONInto(r.Body, &inTag)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -66,10 +156,62 @@ func handleTaskTag(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = db.UpdateAll(collection,
-		bson.M{VersionIdKey: t.Version, NameKey: mux.Vars(r)["name"]},
-		bson.M{"$set": bson.M{TagKey: inTag.Tag}})
+	// a protected tag on another version may only be moved here by force,
+	// and one already here is left as it is
+	movedFrom := []string{}
+	if isProtectedTag(t.Project, inTag.Tag) {
+		versionIds, err := jsonStore.FindTaggedVersionIds(t.Project, name, inTag.Tag)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tagged := false
+		for _, versionId := range versionIds {
+			if versionId != t.Version {
+				movedFrom = append(movedFrom, versionId)
+			} else {
+				tagged = true
+			}
+		}
+		if tagged && len(movedFrom) == 0 {
+			plugin.WriteJSON(w, http.StatusOK, "")
+			return
+		}
+		if len(movedFrom) > 0 {
+			if code, err := checkProtectedTag(r, t.Project, inTag.Tag, author); err != nil {
+				http.Error(w, err.Error(), code)
+				return
+			}
+		}
+	}
+	for _, versionId := range movedFrom {
+		if err = removeTag(t.Project, versionId, name, inTag.Tag, author, inTag.Note); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 
+	info := TagInfo{
+		Name:       inTag.Tag,
+		Author:     author,
+		CreateTime: time.Now(),
+		Note:       inTag.Note,
+	}
+	err = jsonStore.AddTag(t.Version, name, info)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = jsonStore.RecordTagEvent(&TagEvent{
+		ProjectId:  t.Project,
+		VersionId:  t.Version,
+		Name:       name,
+		Tag:        info.Name,
+		Action:     TagAdded,
+		Author:     info.Author,
+		Note:       info.Note,
+		CreateTime: info.CreateTime,
+	})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -77,21 +219,103 @@ func handleTaskTag(w http.ResponseWriter, r *http.Request) {
 	plugin.WriteJSON(w, http.StatusOK, "")
 }
 
+// checkProtectedTag returns an error, and the status code to send with
+// it, if a request may not move or remove a tag.
+func checkProtectedTag(r *http.Request, projectId, tag, username string) (int, error) {
+	if !isProtectedTag(projectId, tag) {
+		return http.StatusOK, nil
+	}
+	if r.FormValue("force") != "true" {
+		return http.StatusConflict, fmt.Errorf("tag '%v' is protected and can only be changed with force=true", tag)
+	}
+	if !isAdmin(projectId, username) {
+		return http.StatusForbidden, fmt.Errorf("only an admin may force a change to protected tag '%v'", tag)
+	}
+	return http.StatusOK, nil
+}
+
+// removeTag removes a tag from the documents stored under name in a
+// version and records it in the tag history.
+func removeTag(projectId, versionId, name, tag, author, note string) error {
+	if err := jsonStore.RemoveTag(versionId, name, tag); err != nil {
+		return err
+	}
+	return jsonStore.RecordTagEvent(&TagEvent{
+		ProjectId:  projectId,
+		VersionId:  versionId,
+		Name:       name,
+		Tag:        tag,
+		Action:     TagRemoved,
+		Author:     author,
+		Note:       note,
+		CreateTime: time.Now(),
+	})
+}
+
+// versionTags returns the distinct tags on the documents stored under name in a version.
+func versionTags(versionId, name string) ([]string, error) {
+	jsonTasks, err := jsonStore.FindByVersion(versionId, name)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	tags := []string{}
+	for _, jsonTask := range jsonTasks {
+		for _, tag := range jsonTask.Tags {
+			if !seen[tag.Name] {
+				seen[tag.Name] = true
+				tags = append(tags, tag.Name)
+			}
+		}
+	}
+	return tags, nil
+}
+
+// filterTags returns the tags in tags named tag.
+func filterTags(tags []string, tag string) []string {
+	filtered := []string{}
+	for _, t := range tags {
+		if t == tag {
+			filtered = append(filtered, t)
+		}
+	}
+	return filtered
+}
+
+// getTagHistory lists the tag changes made in a project, newest first. The
+// "name" and "tag" parameters narrow the list, and "limit" caps its length.
+func getTagHistory(w http.ResponseWriter, r *http.Request) {
+	limit, err := intParam(r, "limit", defaultTagHistoryLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	events, err := jsonStore.FindTagHistory(TagHistoryQuery{
+		ProjectId: mux.Vars(r)["project_id"],
+		Name:      r.FormValue("name"),
+		Tag:       r.FormValue("tag"),
+		Limit:     limit,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	plugin.WriteJSON(w, http.StatusOK, events)
+}
+
 // getTaskJSONByTag finds a TaskJSON by a tag
 func getTaskJSONByTag(w http.ResponseWriter, r *http.Request) {
-	var jsonForTask TaskJSON
-	err := db.FindOneQ(collection,
-		db.Query(bson.M{"project_id": mux.Vars(r)["project_id"],
-			TagKey:      mux.Vars(r)["tag"],
-			VariantKey:  mux.Vars(r)["variant"],
-			TaskNameKey: mux.Vars(r)["task_name"],
-			NameKey:     mux.Vars(r)["name"],
-		}), &jsonForTask)
+	jsonForTask, err := jsonStore.FindByTag(SeriesKey{
+		ProjectId: mux.Vars(r)["project_id"],
+		Variant:   mux.Vars(r)["variant"],
+		TaskName:  mux.Vars(r)["task_name"],
+		Name:      mux.Vars(r)["name"],
+	}, mux.Vars(r)["tag"], fieldsParam(r)...)
 	if err != nil {
-		if err != mgo.ErrNotFound {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if jsonForTask == nil {
 		http.Error(w, "{}", http.StatusNotFound)
 		return
 	}
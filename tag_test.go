@@ -0,0 +1,200 @@
+package evgjson
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// sendTag sends a request to change the tags of the perf documents in a
+// task's version, made by a user unless username is blank.
+func sendTag(t *TaskJSON, method, query string, body interface{}, username string) *httptest.ResponseRecorder {
+	r := newRequest(method, "/task/"+t.TaskId+"/perf/tag"+query, body)
+	if username != "" {
+		r = asUser(r, username)
+	}
+	return serveRoute("/task/{task_id}/{name}/tag", forTask(seriesTask(t.TaskId, t.RevisionOrderNumber), tagTask), r)
+}
+
+// tagNames returns the names of the tags on a task's perf document.
+func tagNames(t *testing.T, taskId string) []string {
+	doc, err := jsonStore.FindByTaskId(taskId, "perf")
+	if err != nil || doc == nil {
+		t.Fatalf("expected a document for %v, got %v (%v)", taskId, doc, err)
+	}
+	names := []string{}
+	for _, tag := range doc.Tags {
+		names = append(names, tag.Name)
+	}
+	return names
+}
+
+func TestTagTask(t *testing.T) {
+	windows := seriesDoc("w1", 1, nil)
+	windows.Variant = "windows"
+	docs := []TaskJSON{seriesDoc("t1", 1, nil), windows, seriesDoc("t2", 2, nil)}
+
+	withMemoryStore(t, docs, func() {
+		for _, tag := range []string{"good", "release"} {
+			if w := sendTag(&docs[0], "POST", "", map[string]string{"tag": tag}, "someone"); w.Code != http.StatusOK {
+				t.Fatalf("expected tag '%v' to be added, got %v: %v", tag, w.Code, w.Body.String())
+			}
+		}
+		// every document under the name in the version is tagged
+		for _, taskId := range []string{"t1", "w1"} {
+			if names := tagNames(t, taskId); !reflect.DeepEqual(names, []string{"good", "release"}) {
+				t.Errorf("%v: expected both tags, got %v", taskId, names)
+			}
+		}
+		if names := tagNames(t, "t2"); len(names) != 0 {
+			t.Errorf("expected another version to be untagged, got %v", names)
+		}
+		if w := sendTag(&docs[0], "POST", "", map[string]string{}, "someone"); w.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for a blank tag, got %v", w.Code)
+		}
+
+		tags, err := jsonStore.ListTags("p")
+		if err != nil || !reflect.DeepEqual(tags, []TagCount{{"good", 2}, {"release", 2}}) {
+			t.Errorf("expected two documents with each tag, got %v (%v)", tags, err)
+		}
+
+		w := serveUI(httptest.NewRequest("GET", "/tag/p/release/windows/bench/perf", nil))
+		doc := TaskJSON{}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &doc) != nil || doc.TaskId != "w1" {
+			t.Errorf("expected the tagged windows document, got %v: %v", w.Code, w.Body.String())
+		}
+
+		if w = sendTag(&docs[0], "DELETE", "?tag=good", nil, "someone"); w.Code != http.StatusOK {
+			t.Fatalf("expected the tag to be removed, got %v", w.Code)
+		}
+		if names := tagNames(t, "t1"); !reflect.DeepEqual(names, []string{"release"}) {
+			t.Errorf("expected only the other tag to be left, got %v", names)
+		}
+		if w = sendTag(&docs[0], "DELETE", "", nil, "someone"); w.Code != http.StatusOK {
+			t.Fatalf("expected every tag to be removed, got %v", w.Code)
+		}
+		if names := tagNames(t, "w1"); len(names) != 0 {
+			t.Errorf("expected no tags to be left, got %v", names)
+		}
+		if w = serveUI(httptest.NewRequest("GET", "/tag/p/release/windows/bench/perf", nil)); w.Code != http.StatusNotFound {
+			t.Errorf("expected no document with a removed tag, got %v", w.Code)
+		}
+	})
+}
+
+func TestTagHistory(t *testing.T) {
+	docs := []TaskJSON{seriesDoc("t1", 1, nil), seriesDoc("t2", 2, nil)}
+
+	withMemoryStore(t, docs, func() {
+		if w := sendTag(&docs[0], "POST", "", map[string]string{"tag": "good", "note": "looks fine"}, "alice"); w.Code != http.StatusOK {
+			t.Fatalf("expected the tag to be added, got %v", w.Code)
+		}
+		doc, _ := jsonStore.FindByTaskId("t1", "perf")
+		if len(doc.Tags) != 1 || doc.Tags[0].Author != "alice" || doc.Tags[0].Note != "looks fine" ||
+			doc.Tags[0].CreateTime.IsZero() {
+			t.Errorf("expected the tag's author, note and time, got %+v", doc.Tags)
+		}
+		sendTag(&docs[1], "POST", "", map[string]string{"tag": "bad"}, "bob")
+		sendTag(&docs[0], "DELETE", "?tag=good&note=oops", nil, "bob")
+
+		w := serveUI(httptest.NewRequest("GET", "/tags/p/history", nil))
+		events := []TagEvent{}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &events) != nil {
+			t.Fatalf("expected the tag history, got %v: %v", w.Code, w.Body.String())
+		}
+		summary := []string{}
+		for _, event := range events {
+			summary = append(summary, event.Action+" "+event.Tag+" "+event.VersionId+" "+event.Author+" "+event.Note)
+		}
+		expected := []string{"remove good v1 bob oops", "add bad v2 bob ", "add good v1 alice looks fine"}
+		if !reflect.DeepEqual(summary, expected) {
+			t.Errorf("expected %v, got %v", expected, summary)
+		}
+
+		for query, expected := range map[string]int{"?tag=good": 2, "?limit=1": 1, "?name=other": 0} {
+			events = []TagEvent{}
+			w = serveUI(httptest.NewRequest("GET", "/tags/p/history"+query, nil))
+			if json.Unmarshal(w.Body.Bytes(), &events) != nil || len(events) != expected {
+				t.Errorf("%v: expected %v events, got %v", query, expected, w.Body.String())
+			}
+		}
+		if w = serveUI(httptest.NewRequest("GET", "/tags/p/history?limit=x", nil)); w.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for a bad limit, got %v", w.Code)
+		}
+	})
+}
+
+func TestTagTaskProtected(t *testing.T) {
+	docs := []TaskJSON{seriesDoc("t1", 1, nil), seriesDoc("t2", 2, nil)}
+
+	withMemoryStore(t, docs, func() {
+		settings = Settings{Projects: map[string]ProjectSettings{"p": {
+			ProtectedTags: []string{"release-*"},
+			Admins:        []string{"admin"},
+		}}}
+		if w := sendTag(&docs[0], "POST", "", map[string]string{"tag": "release-1", "note": "first"}, "someone"); w.Code != http.StatusOK {
+			t.Fatalf("expected a new protected tag to be added, got %v", w.Code)
+		}
+
+		// tagging the same version again leaves the tag as it was
+		if w := sendTag(&docs[0], "POST", "", map[string]string{"tag": "release-1", "note": "second"}, "other"); w.Code != http.StatusOK {
+			t.Fatalf("expected re-tagging the same version to succeed, got %v", w.Code)
+		}
+		doc, _ := jsonStore.FindByTaskId("t1", "perf")
+		if len(doc.Tags) != 1 || doc.Tags[0].Author != "someone" || doc.Tags[0].Note != "first" {
+			t.Errorf("expected the tag's metadata to be kept, got %+v", doc.Tags)
+		}
+		if events, _ := jsonStore.FindTagHistory(TagHistoryQuery{ProjectId: "p"}); len(events) != 1 {
+			t.Errorf("expected only the first tagging to be recorded, got %+v", events)
+		}
+
+		// moving or removing the tag needs force from an admin
+		cases := []struct {
+			method, query, username string
+			code                    int
+		}{
+			{"POST", "", "someone", http.StatusConflict},
+			{"POST", "?force=true", "someone", http.StatusForbidden},
+			{"DELETE", "?tag=release-1", "admin", http.StatusConflict},
+			{"DELETE", "", "someone", http.StatusConflict},
+			{"DELETE", "?tag=release-1&force=true", "someone", http.StatusForbidden},
+		}
+		for _, c := range cases {
+			target := &docs[0]
+			if c.method == "POST" {
+				target = &docs[1]
+			}
+			w := sendTag(target, c.method, c.query, map[string]string{"tag": "release-1"}, c.username)
+			if w.Code != c.code {
+				t.Errorf("%v %v by %v: expected %v, got %v", c.method, c.query, c.username, c.code, w.Code)
+			}
+		}
+		if names := tagNames(t, "t1"); !reflect.DeepEqual(names, []string{"release-1"}) {
+			t.Fatalf("expected the tag not to have moved, got %v", names)
+		}
+
+		// a protected tag the version doesn't have isn't in the way of a removal
+		sendTag(&docs[1], "POST", "", map[string]string{"tag": "good"}, "someone")
+		if w := sendTag(&docs[1], "DELETE", "?tag=release-1", nil, "someone"); w.Code != http.StatusOK {
+			t.Errorf("expected removing a tag the version lacks to succeed, got %v", w.Code)
+		}
+		if w := sendTag(&docs[1], "DELETE", "", nil, "someone"); w.Code != http.StatusOK {
+			t.Errorf("expected removing the version's unprotected tags to succeed, got %v", w.Code)
+		}
+
+		if w := sendTag(&docs[1], "POST", "?force=true", map[string]string{"tag": "release-1"}, "admin"); w.Code != http.StatusOK {
+			t.Fatalf("expected an admin to move the tag by force, got %v", w.Code)
+		}
+		if names := tagNames(t, "t1"); len(names) != 0 {
+			t.Errorf("expected the tag to be moved off the old version, got %v", names)
+		}
+		if names := tagNames(t, "t2"); !reflect.DeepEqual(names, []string{"release-1"}) {
+			t.Errorf("expected the tag on the new version, got %v", names)
+		}
+		if w := sendTag(&docs[1], "DELETE", "?force=true", nil, "admin"); w.Code != http.StatusOK {
+			t.Errorf("expected an admin to remove the tag by force, got %v", w.Code)
+		}
+	})
+}
@@ -1,26 +1,54 @@
 package evgjson
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/evergreen-ci/evergreen"
 	"github.com/evergreen-ci/evergreen/db"
 	"github.com/evergreen-ci/evergreen/model/task"
 	"github.com/evergreen-ci/evergreen/plugin"
-	"github.com/evergreen-ci/evergreen/util"
 	"github.com/gorilla/mux"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 	"net/http"
 )
 
-// getTaskById sends back a JSONTask with the corresponding task id.
+// executionParam reads a task execution query parameter, returning -1 for
+// the latest execution if it is not set.
+func executionParam(r *http.Request, name string) (int, error) {
+	execution, err := intParam(r, name, -1)
+	if err != nil {
+		return 0, err
+	}
+	if execution < -1 {
+		return 0, fmt.Errorf("invalid %v '%v'", name, execution)
+	}
+	return execution, nil
+}
+
+// findExecution returns the document an execution of a task stored under
+// name, or the document its latest execution stored if execution is -1.
+func findExecution(taskId, name string, execution int, fields ...string) (*TaskJSON, error) {
+	if execution < 0 {
+		return jsonStore.FindByTaskId(taskId, name, fields...)
+	}
+	return jsonStore.FindByTaskExecution(taskId, name, execution, fields...)
+}
+
+// getTaskById sends back a JSONTask with the corresponding task id. The
+// "execution" parameter picks an execution of the task other than its latest.
 func getTaskById(w http.ResponseWriter, r *http.Request) {
-	var jsonForTask TaskJSON
-	err := db.FindOneQ(collection, db.Query(bson.M{TaskIdKey: mux.Vars(r)["task_id"], NameKey: mux.Vars(r)["name"]}), &jsonForTask)
+	execution, err := executionParam(r, "execution")
 	if err != nil {
-		if err != mgo.ErrNotFound {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	jsonForTask, err := findExecution(mux.Vars(r)["task_id"], mux.Vars(r)["name"], execution, fieldsParam(r)...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if jsonForTask == nil {
 		http.Error(w, "{}", http.StatusNotFound)
 		return
 	}
@@ -29,26 +57,35 @@ func getTaskById(w http.ResponseWriter, r *http.Request) {
 }
 
 // getTask finds a json document by using thex task that is in the plugin.
+// The "execution" parameter picks an execution of the named task other
+// than its latest.
 func getTaskByName(w http.ResponseWriter, r *http.Request) {
 	t := plugin.GetTask(r)
 	if t == nil {
 		http.Error(w, "task not found", http.StatusNotFound)
 		return
 	}
+	execution, err := executionParam(r, "execution")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	name := mux.Vars(r)["name"]
 	taskName := mux.Vars(r)["task_name"]
 
-	var jsonForTask TaskJSON
-	err := db.FindOneQ(collection, db.Query(bson.M{VersionIdKey: t.Version, BuildIdKey: t.BuildId, NameKey: name,
-		TaskNameKey: taskName}), &jsonForTask)
+	jsonForTask, err := jsonStore.FindByBuild(t.Version, t.BuildId, taskName, name, fieldsParam(r)...)
+	if err == nil && jsonForTask != nil && execution >= 0 {
+		// the latest execution's document names the task to look in
+		jsonForTask, err = jsonStore.FindByTaskExecution(jsonForTask.TaskId, name, execution, fieldsParam(r)...)
+	}
 	if err != nil {
-		if err == mgo.ErrNotFound {
-			plugin.WriteJSON(w, http.StatusNotFound, nil)
-			return
-		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if jsonForTask == nil {
+		plugin.WriteJSON(w, http.StatusNotFound, nil)
+		return
+	}
 	if len(r.FormValue("full")) != 0 { // if specified, include the json data's container as well
 		plugin.WriteJSON(w, http.StatusOK, jsonForTask)
 		return
@@ -58,12 +95,19 @@ func getTaskByName(w http.ResponseWriter, r *http.Request) {
 
 // getTaskForVariant finds a task by name and variant and finds
 // the document in the json collection associated with that task's id.
+// The "execution" parameter picks an execution of that task other than its
+// latest.
 func getTaskForVariant(w http.ResponseWriter, r *http.Request) {
 	t := plugin.GetTask(r)
 	if t == nil {
 		http.Error(w, "task not found", http.StatusNotFound)
 		return
 	}
+	execution, err := executionParam(r, "execution")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	name := mux.Vars(r)["name"]
 	taskName := mux.Vars(r)["task_name"]
 	variantId := mux.Vars(r)["variant"]
@@ -84,16 +128,15 @@ func getTaskForVariant(w http.ResponseWriter, r *http.Request) {
 	}
 	otherVariantTask := ts[0]
 
-	var jsonForTask TaskJSON
-	err = db.FindOneQ(collection, db.Query(bson.M{TaskIdKey: otherVariantTask.Id, NameKey: name}), &jsonForTask)
+	jsonForTask, err := findExecution(otherVariantTask.Id, name, execution, fieldsParam(r)...)
 	if err != nil {
-		if err == mgo.ErrNotFound {
-			plugin.WriteJSON(w, http.StatusNotFound, nil)
-			return
-		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if jsonForTask == nil {
+		plugin.WriteJSON(w, http.StatusNotFound, nil)
+		return
+	}
 	if len(r.FormValue("full")) != 0 { // if specified, include the json data's container as well
 		plugin.WriteJSON(w, http.StatusOK, jsonForTask)
 		return
@@ -101,21 +144,27 @@ func getTaskForVariant(w http.ResponseWriter, r *http.Request) {
 	plugin.WriteJSON(w, http.StatusOK, jsonForTask.Data)
 }
 
-// getTaskByTag returns a TaskJSON with a specific task name and tag
+// getTaskByTag returns a TaskJSON with a specific task name and tag. If the
+// Accept header asks for newline delimited json, the documents are streamed.
 func getTaskByTag(w http.ResponseWriter, r *http.Request) {
 	t := plugin.GetTask(r)
 	if t == nil {
 		http.Error(w, "task not found", http.StatusNotFound)
 		return
 	}
-	tagged := []TaskJSON{}
-	jsonQuery := db.Query(bson.M{
-		ProjectIdKey: t.Project,
-		VariantKey:   t.BuildVariant,
-		TaskNameKey:  mux.Vars(r)["task_name"],
-		TagKey:       bson.M{"$exists": true, "$ne": ""},
-		NameKey:      mux.Vars(r)["name"]})
-	err := db.FindAllQ(collection, jsonQuery, &tagged)
+	key := SeriesKey{
+		ProjectId: t.Project,
+		Variant:   t.BuildVariant,
+		TaskName:  mux.Vars(r)["task_name"],
+		Name:      mux.Vars(r)["name"],
+	}
+	if wantsNDJSON(r) {
+		streamDocs(w, http.StatusOK, func(each func(*TaskJSON) error) error {
+			return jsonStore.IterTagged(key, fieldsParam(r), each)
+		})
+		return
+	}
+	tagged, err := jsonStore.FindTagged(key, fieldsParam(r)...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -123,7 +172,11 @@ func getTaskByTag(w http.ResponseWriter, r *http.Request) {
 	plugin.WriteJSON(w, http.StatusOK, tagged)
 }
 
-// insertTask creates a TaskJSON document with the data sent in the request body.
+// insertTask creates a TaskJSON document with the data sent in the request
+// body. The "mode" parameter says how the data is combined with any data
+// the task's execution already stored under the name: ReplaceMode, the
+// default, MergeMode or AppendMode. Documents stored by earlier executions
+// of the task are kept.
 func insertTask(w http.ResponseWriter, r *http.Request) {
 	t := plugin.GetTask(r)
 	if t == nil {
@@ -131,12 +184,63 @@ func insertTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	name := mux.Vars(r)["name"]
-	rawData := map[string]interface{}{}
-	err := util.ReadJSONInto(r.Body, &rawData)
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = ReplaceMode
+	}
+	if err := validateMode(mode); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	body, err := readPayload(r, settings.maxPayloadBytes())
+	if _, ok := err.(errPayloadTooLarge); ok {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// the document may be any json value: an object, an array or a scalar
+	var rawData interface{}
+	if err = json.Unmarshal(body, &rawData); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rawData == nil {
+		http.Error(w, "data must not be null", http.StatusBadRequest)
+		return
+	}
+
+	// check what the stored data will be once it is combined with this
+	combined := rawData
+	if mode != ReplaceMode {
+		existing, err := jsonStore.FindByTaskExecution(t.Id, name, t.Execution)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var existingData interface{}
+		if existing != nil {
+			existingData = existing.Data
+		}
+		if combined, err = combineData(mode, existingData, rawData); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	violations, err := validateForProject(t.Project, name, combined)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if len(violations) > 0 {
+		plugin.WriteJSON(w, http.StatusBadRequest, SchemaViolations{
+			Message: fmt.Sprintf("data does not match the schema for '%v'", name),
+			Errors:  violations,
+		})
+		return
+	}
 	jsonBlob := TaskJSON{
 		TaskId:              t.Id,
 		TaskName:            t.DisplayName,
@@ -149,11 +253,27 @@ func insertTask(w http.ResponseWriter, r *http.Request) {
 		Revision:            t.Revision,
 		RevisionOrderNumber: t.RevisionOrderNumber,
 		Data:                rawData,
+		Execution:           t.Execution,
 		IsPatch:             t.Requester == evergreen.PatchVersionRequester,
 	}
-	_, err = db.Upsert(collection, bson.M{TaskIdKey: t.Id, NameKey: name}, jsonBlob)
+	switch mode {
+	case MergeMode:
+		err = jsonStore.Merge(&jsonBlob)
+	case AppendMode:
+		err = jsonStore.Append(&jsonBlob)
+	default:
+		err = jsonStore.Insert(&jsonBlob)
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		code := http.StatusInternalServerError
+		// retrying can't make the data fit, or make stored data updatable
+		switch err.(type) {
+		case errPayloadTooLarge:
+			code = http.StatusRequestEntityTooLarge
+		case errNotUpdatable:
+			code = http.StatusConflict
+		}
+		http.Error(w, err.Error(), code)
 		return
 	}
 	plugin.WriteJSON(w, http.StatusOK, "ok")
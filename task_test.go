@@ -0,0 +1,86 @@
+package evgjson
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/evergreen-ci/evergreen/plugin"
+)
+
+// sendData sends data to the insert route as the task seriesTask(taskId, order).
+func sendData(taskId string, order int, query string, data interface{}) *httptest.ResponseRecorder {
+	r := newRequest("POST", "/data/perf"+query, data)
+	plugin.SetTask(r, seriesTask(taskId, order))
+	return serveRoute("/data/{name}", insertTask, r)
+}
+
+func TestInsertTaskPayloadLimit(t *testing.T) {
+	withMemoryStore(t, nil, func() {
+		settings = Settings{MaxPayloadBytes: 32}
+		if w := sendData("t1", 1, "", map[string]interface{}{"ops": strings.Repeat("x", 32)}); w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("expected 413 for a body over the limit, got %v", w.Code)
+		}
+		if doc, _ := jsonStore.FindByTaskId("t1", "perf"); doc != nil {
+			t.Errorf("expected nothing to be stored, got %+v", doc)
+		}
+		if w := sendData("t1", 1, "", map[string]interface{}{"ops": 1.0}); w.Code != http.StatusOK {
+			t.Errorf("expected a body within the limit to be stored, got %v: %v", w.Code, w.Body.String())
+		}
+	})
+}
+
+// serveGet sends a GET request accepting a content type, unless accept is
+// blank, to the plugin's UI routes.
+func serveGet(url, accept string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", url, nil)
+	if accept != "" {
+		r.Header.Set("Accept", accept)
+	}
+	return serveUI(r)
+}
+
+func TestGetTaskByIdRoute(t *testing.T) {
+	rerun := seriesDoc("t1", 1, map[string]interface{}{"ops": 120.0})
+	rerun.Execution = 1
+	docs := []TaskJSON{seriesDoc("t1", 1, map[string]interface{}{"ops": 100.0, "other": 1.0}), rerun}
+
+	withMemoryStore(t, docs, func() {
+		w := serveGet("/task/t1/perf/", "")
+		doc := TaskJSON{}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &doc) != nil {
+			t.Fatalf("expected a document, got %v: %v", w.Code, w.Body.String())
+		}
+		if doc.Execution != 1 || !reflect.DeepEqual(doc.Data, map[string]interface{}{"ops": 120.0}) {
+			t.Errorf("expected the latest execution, got %+v", doc)
+		}
+
+		w = serveGet("/task/t1/perf/?execution=0&fields=ops", "")
+		doc = TaskJSON{}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &doc) != nil {
+			t.Fatalf("expected a document, got %v: %v", w.Code, w.Body.String())
+		}
+		if doc.Execution != 0 || !reflect.DeepEqual(doc.Data, map[string]interface{}{"ops": 100.0}) {
+			t.Errorf("expected the projected first execution, got %+v", doc)
+		}
+
+		if w = serveGet("/task/t1/perf/?execution=x", ""); w.Code != http.StatusBadRequest {
+			t.Errorf("invalid execution: expected 400, got %v", w.Code)
+		}
+		if w = serveGet("/task/t1/perf/?execution=2", ""); w.Code != http.StatusNotFound {
+			t.Errorf("missing execution: expected 404, got %v", w.Code)
+		}
+		if w = serveGet("/task/t3/perf/", ""); w.Code != http.StatusNotFound {
+			t.Errorf("missing task: expected 404, got %v", w.Code)
+		}
+
+		// the earlier execution is superseded everywhere else
+		versionDocs, err := jsonStore.FindByVersion("v1", "perf")
+		if err != nil || len(versionDocs) != 1 || versionDocs[0].Execution != 1 {
+			t.Errorf("expected only the latest execution in the version, got %+v (%v)", versionDocs, err)
+		}
+	})
+}
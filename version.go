@@ -2,15 +2,13 @@ package evgjson
 
 import (
 	"net/http"
+	"sort"
 	"time"
 
-	"github.com/evergreen-ci/evergreen/db"
 	"github.com/evergreen-ci/evergreen/model/version"
 	"github.com/evergreen-ci/evergreen/plugin"
 	"github.com/evergreen-ci/evergreen/util"
 	"github.com/gorilla/mux"
-	"gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
 )
 
 // CommitInfo represents the information about the commit
@@ -29,37 +27,56 @@ type VersionData struct {
 	Commit    CommitInfo `json:"commit_info"`
 }
 
+// VersionMatrix pivots the documents stored under a name in a version
+// into a table of variants by tasks, with the values at the selected
+// paths in each cell.
+type VersionMatrix struct {
+	VersionId string   `json:"version_id"`
+	Name      string   `json:"name"`
+	Paths     []string `json:"paths"`
+	Variants  []string `json:"variants"`
+	Tasks     []string `json:"tasks"`
+	// Cells maps variant, then task name, then path to the value there.
+	// Paths with no value are left out.
+	Cells map[string]map[string]map[string]interface{} `json:"cells"`
+}
+
 // getVersion returns a StatusOK if the route is hit
 func getVersion(w http.ResponseWriter, r *http.Request) {
 	plugin.WriteJSON(w, http.StatusOK, "1")
 }
 
-// findTasksForVersion sends back the list of TaskJSON documents associated with a version id.
-func findTasksForVersion(versionId, name string) ([]TaskJSON, error) {
-	var jsonForTasks []TaskJSON
-	err := db.FindAllQ(collection, db.Query(bson.M{VersionIdKey: versionId,
-		NameKey: name}), &jsonForTasks)
+// getTasksForVersion sends back the list of TaskJSON documents associated with a version id,
+// as json or, if the "format" parameter asks for it, comma or tab separated values. If the
+// Accept header asks for newline delimited json, the documents are streamed.
+func getTasksForVersion(w http.ResponseWriter, r *http.Request) {
+	versionId := mux.Vars(r)["version_id"]
+	name := mux.Vars(r)["name"]
+	format, err := tableFormat(r)
 	if err != nil {
-		if err != mgo.ErrNotFound {
-			return nil, err
-		}
-		return nil, err
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-
-	return jsonForTasks, err
-}
-
-// getTasksForVersion sends back the list of TaskJSON documents associated with a version id.
-func getTasksForVersion(w http.ResponseWriter, r *http.Request) {
-	jsonForTasks, err := findTasksForVersion(mux.Vars(r)["version_id"], mux.Vars(r)["name"])
-	if jsonForTasks == nil {
-		http.Error(w, "{}", http.StatusNotFound)
+	iter := func(each func(*TaskJSON) error) error {
+		return jsonStore.IterByVersion(versionId, name, fieldsParam(r), each)
+	}
+	if format != "" {
+		writeTable(w, format, http.StatusNotFound, iter)
 		return
 	}
+	if wantsNDJSON(r) {
+		streamDocs(w, http.StatusNotFound, iter)
+		return
+	}
+	jsonForTasks, err := jsonStore.FindByVersion(versionId, name, fieldsParam(r)...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if len(jsonForTasks) == 0 {
+		http.Error(w, "{}", http.StatusNotFound)
+		return
+	}
 	plugin.WriteJSON(w, http.StatusOK, jsonForTasks)
 	return
 }
@@ -68,7 +85,6 @@ func getTasksForVersion(w http.ResponseWriter, r *http.Request) {
 func getTasksForLatestVersion(w http.ResponseWriter, r *http.Request) {
 
 	name := mux.Vars(r)["name"]
-	var jsonTask TaskJSON
 
 	projects := []string{}
 	err := util.ReadJSONInto(r.Body, &projects)
@@ -79,31 +95,27 @@ func getTasksForLatestVersion(w http.ResponseWriter, r *http.Request) {
 
 	versionData := []VersionData{}
 	for _, project := range projects {
-		err := db.FindOneQ(collection, db.Query(bson.M{NameKey: name,
-			ProjectIdKey: project}).Sort([]string{"-" + RevisionOrderNumberKey}).WithFields(VersionIdKey), &jsonTask)
+		versionId, err := jsonStore.FindLatestVersionId(project, name)
 		if err != nil {
-			if err != mgo.ErrNotFound {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			http.Error(w, "{}", http.StatusNotFound)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		if jsonTask.VersionId == "" {
-			http.Error(w, "{}", http.StatusNotFound)
-		}
-		jsonTasks, err := findTasksForVersion(jsonTask.VersionId, name)
-		if jsonTasks == nil {
+		if versionId == "" {
 			http.Error(w, "{}", http.StatusNotFound)
 			return
 		}
+		jsonTasks, err := jsonStore.FindByVersion(versionId, name, fieldsParam(r)...)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if len(jsonTasks) == 0 {
+			http.Error(w, "{}", http.StatusNotFound)
+			return
+		}
 
 		// get the version commit info
-		v, err := version.FindOne(version.ById(jsonTask.VersionId))
+		v, err := version.FindOne(version.ById(versionId))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -118,10 +130,77 @@ func getTasksForLatestVersion(w http.ResponseWriter, r *http.Request) {
 			Message:    v.Message,
 			CreateTime: v.CreateTime,
 			Revision:   v.Revision,
-			VersionId:  jsonTask.VersionId,
+			VersionId:  versionId,
 		}
 
 		versionData = append(versionData, VersionData{jsonTasks, commitInfo})
 	}
 	plugin.WriteJSON(w, http.StatusOK, versionData)
 }
+
+// buildVersionMatrix pivots a version's documents into a VersionMatrix.
+func buildVersionMatrix(versionId, name string, paths []string, jsonTasks []TaskJSON) VersionMatrix {
+	matrix := VersionMatrix{
+		VersionId: versionId,
+		Name:      name,
+		Paths:     paths,
+		Variants:  []string{},
+		Tasks:     []string{},
+		Cells:     map[string]map[string]map[string]interface{}{},
+	}
+	seenTasks := map[string]bool{}
+	for _, jsonTask := range jsonTasks {
+		if _, ok := matrix.Cells[jsonTask.Variant]; !ok {
+			matrix.Cells[jsonTask.Variant] = map[string]map[string]interface{}{}
+			matrix.Variants = append(matrix.Variants, jsonTask.Variant)
+		}
+		if !seenTasks[jsonTask.TaskName] {
+			seenTasks[jsonTask.TaskName] = true
+			matrix.Tasks = append(matrix.Tasks, jsonTask.TaskName)
+		}
+		cell := map[string]interface{}{}
+		for _, path := range paths {
+			if value, ok := lookupPath(jsonTask.Data, path); ok {
+				cell[path] = value
+			}
+		}
+		matrix.Cells[jsonTask.Variant][jsonTask.TaskName] = cell
+	}
+	sort.Strings(matrix.Variants)
+	sort.Strings(matrix.Tasks)
+	return matrix
+}
+
+// getVersionMatrix sends back the documents stored under a name in a
+// version as a matrix of variants by tasks, holding the values at the
+// paths given by the "path" parameter.
+func getVersionMatrix(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	paths := []string{}
+	for _, path := range r.Form["path"] {
+		if len(splitPath(path)) > 0 {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		http.Error(w, "at least one path must be given", http.StatusBadRequest)
+		return
+	}
+
+	// only fetch the parts of each document that lead to the paths
+	versionId := mux.Vars(r)["version_id"]
+	name := mux.Vars(r)["name"]
+	jsonTasks, err := jsonStore.FindByVersion(versionId, name, normalizeFields(paths)...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(jsonTasks) == 0 {
+		http.Error(w, "{}", http.StatusNotFound)
+		return
+	}
+	plugin.WriteJSON(w, http.StatusOK, buildVersionMatrix(versionId, name, paths, jsonTasks))
+}
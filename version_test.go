@@ -0,0 +1,53 @@
+package evgjson
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestGetVersionMatrix(t *testing.T) {
+	windows := seriesDoc("w1", 1, map[string]interface{}{"ops": 2.0, "results": []interface{}{4.0}})
+	windows.Variant = "windows"
+	compile := seriesDoc("c1", 1, map[string]interface{}{"ops": 3.0})
+	compile.TaskName = "compile"
+	docs := []TaskJSON{
+		seriesDoc("t1", 1, map[string]interface{}{"ops": 1.0, "other": 5.0}),
+		windows,
+		compile,
+		seriesDoc("t2", 2, map[string]interface{}{"ops": 6.0}),
+	}
+
+	withMemoryStore(t, docs, func() {
+		w := serveUI(httptest.NewRequest("GET", "/version/v1/perf/matrix?path=ops&path=results.0", nil))
+		matrix := VersionMatrix{}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &matrix) != nil {
+			t.Fatalf("expected the matrix, got %v: %v", w.Code, w.Body.String())
+		}
+		if !reflect.DeepEqual(matrix.Variants, []string{"linux", "windows"}) ||
+			!reflect.DeepEqual(matrix.Tasks, []string{"bench", "compile"}) {
+			t.Errorf("expected sorted variants and tasks, got %v and %v", matrix.Variants, matrix.Tasks)
+		}
+		expected := map[string]map[string]map[string]interface{}{
+			"linux": {
+				"bench":   {"ops": 1.0},
+				"compile": {"ops": 3.0},
+			},
+			"windows": {
+				"bench": {"ops": 2.0, "results.0": 4.0},
+			},
+		}
+		if !reflect.DeepEqual(matrix.Cells, expected) {
+			t.Errorf("expected %v, got %v", expected, matrix.Cells)
+		}
+
+		if w = serveUI(httptest.NewRequest("GET", "/version/v1/perf/matrix", nil)); w.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 without a path, got %v", w.Code)
+		}
+		if w = serveUI(httptest.NewRequest("GET", "/version/v9/perf/matrix?path=ops", nil)); w.Code != http.StatusNotFound {
+			t.Errorf("expected 404 for a version with no documents, got %v", w.Code)
+		}
+	})
+}